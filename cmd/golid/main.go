@@ -1,41 +1,90 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"io/ioutil"
 	"os"
 
 	"github.com/refola/golid/parse"
 )
 
-const usage = `Usage: golid [-reparse] file1.gol [file2.gol [...]]
+const usage = `Usage: golid [-debug] [-reparse] [-o out.go] [file1.gol [file2.gol [...]]]
 
-Converts Golid code into Go, producing gol_file1.gol, etc.
+Converts Golid code into Go, producing gol_file1.go, etc.
 
-If the first argument is "-reparse", then print the Lisp parse tree to
-standart output instead.
+If no files are given, or the only file is "-", then Golid code is
+read from standard input and Go is written to standard output. With
+"-o out.go", the Go from a single file or standard input is written
+to out.go instead.
+
+If "-reparse" is given, then print the Lisp parse tree to standard
+output instead.
+
+If "-debug" is given, then print each conversion step to standard
+error.
 `
 
+// Print an error to standard error and exit with a failure.
+func fail(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
+
 func main() {
-	args := os.Args[1:]
-	if len(args) == 0 {
-		fmt.Println(usage)
-		os.Exit(1)
-	} else if args[0] == "-reparse" {
-		for _, file := range args[1:] {
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	debug := flag.Bool("debug", false, "print each conversion step")
+	reparse := flag.Bool("reparse", false, "print the Lisp parse tree")
+	out := flag.String("o", "", "write Go to this file")
+	flag.Parse()
+	args := flag.Args()
+	if *debug {
+		parse.SetDebugOutput(os.Stderr)
+	}
+	switch {
+	case *reparse:
+		for _, file := range args {
 			parsed, err := parse.ReadGolid(file)
 			if err != nil {
-				fmt.Printf("Error converting %s: %s\n", file, err)
+				fail("Error converting %s: %s", file, err)
 			}
 			reparsed := parsed.String()
 			reparsed = reparsed[1 : len(reparsed)-1] // remove wrapping "()"
 			fmt.Println(reparsed)
 		}
-	} else {
+	case len(args) == 0 || len(args) == 1 && (args[0] == "-" || *out != ""):
+		var golid []byte
+		var err error
+		if len(args) == 0 || args[0] == "-" {
+			golid, err = ioutil.ReadAll(os.Stdin)
+		} else {
+			golid, err = ioutil.ReadFile(args[0])
+		}
+		if err != nil {
+			fail("Error reading Golid: %s", err)
+		}
+		code, err := parse.ConvertString(string(golid))
+		if err != nil {
+			fail("Error converting Golid: %s", err)
+		}
+		if *out == "" {
+			fmt.Print(code)
+		} else if err := ioutil.WriteFile(*out, []byte(code), 0644); err != nil {
+			fail("Error writing %s: %s", *out, err)
+		}
+	case *out != "":
+		fail("Can only use -o with a single input file.")
+	default:
+		failed := false
 		for _, file := range args {
 			err := parse.Convert(file)
 			if err != nil {
-				fmt.Printf("Error converting %s: %s\n", file, err)
+				fmt.Fprintf(os.Stderr, "Error converting %s: %s\n", file, err)
+				failed = true
 			}
 		}
+		if failed {
+			os.Exit(1)
+		}
 	}
 }
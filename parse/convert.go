@@ -5,6 +5,7 @@ package parse
 import (
 	"fmt"
 	"io/ioutil"
+	"strings"
 )
 
 // split path into directory, filename, and extension, based on
@@ -35,7 +36,7 @@ func dirNameExt(path string) (string, string, string) {
 	return dir, name, ext
 }
 
-// Read a file into a Golid syntax tree
+// Read a file into a Golid syntax tree, checking it like ParseFile.
 func ReadGolid(golfile string) (Expression, error) {
 	_, _, ext := dirNameExt(golfile)
 	if ext != "gol" {
@@ -45,23 +46,161 @@ func ReadGolid(golfile string) (Expression, error) {
 	if err != nil {
 		return nil, err
 	}
-	lispText := string(lispBytes)
-	return parseString(lispText)
+	root, err := ParseFile(string(lispBytes))
+	if err != nil {
+		return nil, err
+	}
+	return root, nil
 }
 
-// Convert a Golid file into Go.
-func Convert(golfile string) error {
+// Convert a parsed Golid syntax tree into formatted Go, turning
+// panics from invalid Golid into errors, which keep any wrapped errors
+// like UnknownNodeError. If the generated Go is
+// invalid, then it's returned unformatted along with the error from
+// formatting it.
+func toGo(parsed Expression) (go_text string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = panicError(r)
+		}
+	}()
+	return parsed.FormatGo()
+}
+
+// Turn a recovered panic into an error, keeping it as-is if it's
+// already an error.
+func panicError(r interface{}) error {
+	if err, ok := r.(error); ok {
+		return err
+	}
+	return fmt.Errorf("%v", r)
+}
+
+// Convert a whole file's worth of Golid code into formatted Go,
+// checking it like ParseFile.
+func ConvertString(golid string) (string, error) {
+	parsed, err := ParseFile(golid)
+	if err != nil {
+		return "", err
+	}
+	return toGo(parsed)
+}
+
+// An Option changes how Transpile and TranspileFile convert Golid.
+type Option func(*options)
+
+type options struct {
+	indent bool
+}
+
+// Indent Go that can't be formatted by how deeply it's nested, so that
+// what's returned alongside the formatting error is still readable.
+// Valid Go is formatted by go/format either way.
+func Indent() Option {
+	return func(o *options) { o.indent = true }
+}
+
+// Apply the options to unformatted Go that came with an error.
+func applyOptions(code string, err error, opts []Option) (string, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if err != nil && o.indent {
+		code = indentGo(code)
+	}
+	return code, err
+}
+
+// Convert Golid code into formatted Go, doing everything from parsing
+// to formatting. Without options, this is the same as ConvertString,
+// and is what most users of this package want.
+func Transpile(src string, opts ...Option) (string, error) {
+	code, err := ConvertString(src)
+	return applyOptions(code, err, opts)
+}
+
+// Convert a Golid file into formatted Go, returning the Go instead of
+// writing it to a file like Convert does. If the generated Go is
+// invalid, then it's returned unformatted along with the error from
+// formatting it.
+func TranspileFile(golfile string, opts ...Option) (string, error) {
 	parsed, err := ReadGolid(golfile)
 	if err != nil {
-		return err
+		return "", err
+	}
+	code, err := toGo(parsed)
+	return applyOptions(code, err, opts)
+}
+
+// Indent each line of Go by how many brackets are open before it,
+// with one less tab for closing brackets and switch cases, like
+// gofmt does. Brackets in strings, runes and comments don't count, and
+// lines inside raw strings are left alone.
+func indentGo(code string) string {
+	lines := strings.Split(code, "\n")
+	depth, inRaw := 0, false
+	for i, line := range lines {
+		if !inRaw {
+			line = strings.TrimLeft(line, " \t")
+			indent := depth
+			for j := 0; j < len(line) && strings.IndexByte("}])", line[j]) >= 0; j++ {
+				indent--
+			}
+			if strings.HasPrefix(line, "case ") || strings.HasPrefix(line, "default:") {
+				indent--
+			}
+			if line != "" && indent > 0 {
+				lines[i] = strings.Repeat("\t", indent) + line
+			} else {
+				lines[i] = line
+			}
+		}
+		quote := byte(0)
+		if inRaw {
+			quote = '`'
+		}
+		for j := 0; j < len(line); j++ {
+			c := line[j]
+			switch {
+			case quote != 0:
+				if c == '\\' && quote != '`' {
+					j++
+				} else if c == quote {
+					quote = 0
+				}
+			case c == '"' || c == '\'' || c == '`':
+				quote = c
+			case c == '/' && strings.HasPrefix(line[j:], "//"):
+				j = len(line)
+			case c == '{' || c == '(' || c == '[':
+				depth++
+			case c == '}' || c == ')' || c == ']':
+				depth--
+			}
+		}
+		inRaw = quote == '`'
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Convert a Golid file into formatted Go. If the generated Go is
+// invalid, then it's still written unformatted, but the error from
+// formatting it is returned.
+func Convert(golfile string) error {
+	go_text, fmtErr := TranspileFile(golfile)
+	if go_text == "" && fmtErr != nil {
+		return fmtErr
 	}
-	go_text := parsed.GoString()
 	dir, name, ext := dirNameExt(golfile)
 	if dir == "" {
 		// make sure that following dir with "/" doesn't change semantics
 		dir = "."
 	}
 	gofile := fmt.Sprintf("%s/%s_%s.go", dir, ext, name)
-	err = ioutil.WriteFile(gofile, []byte(go_text), 0644)
-	return err
+	err := ioutil.WriteFile(gofile, []byte(go_text), 0644)
+	if err != nil {
+		return err
+	}
+	return fmtErr
 }
@@ -3,7 +3,16 @@
 package parse
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"go/ast"
+	"go/format"
+	"go/importer"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"go/types"
 	"io/ioutil"
 	"runtime"
 	"strings"
@@ -154,12 +163,57 @@ func TestDirNameExt(t *testing.T) {
 
 func TestNodeProcessValue(t *testing.T) {
 	cases := map[string]string{
-		"(< n 2)":                         "(n < 2)",
-		"(- n 1)":                         "(n - 1)",
-		"(fib (- n 1))":                   "fib((n - 1))",
-		"(+ (fib (- n 1)) (fib (- n 2)))": "(fib((n - 1)) + fib((n - 2)))",
-		"5":     "5",
-		`"foo"`: `"foo"`,
+		"(< n 2)":                         "n < 2",
+		"(- n 1)":                         "n - 1",
+		"(fib (- n 1))":                   "fib(n - 1)",
+		"(+ (fib (- n 1)) (fib (- n 2)))": "fib(n - 1) + fib(n - 2)",
+		"5":                               "5",
+		`"foo"`:                           `"foo"`,
+		"(& x)":                           "&x",
+		"(- n)":                           "-n",
+		"x":                               "x",
+		"(+ 1 2)":                         "1 + 2",
+		"(f (g (h x)))":                   "f(g(h(x)))",
+		"(foo a b c)":                     "foo(a, b, c)",
+		"(foo (bar x) y)":                 "foo(bar(x), y)",
+		"(<- ch)":                         "<-ch",
+		"(f (<- ch) (<- (. s done)))":     "f(<-ch, <-s.done)",
+		"(&& a b)":                        "a && b",
+		"(|| (< a b) (! ok))":             "a < b || !ok",
+		"(&& a b c)":                      "a && b && c",
+		"(+ a b c)":                       "a + b + c",
+		"(lambda () (int) (return 1))":    "func() int {\nreturn 1\n}",
+		"(lambda () ((* T)) (return p))":  "func() *T {\nreturn p\n}",
+		"(lambda () (int error) (f))":     "func()(int, error) {\nf()\n}",
+		"(lambda () ((n int)) (return))":  "func()(n int) {\nreturn\n}",
+		"(>= (len s) n)":                  "len(s) >= n",
+		// generic calls with inferred and explicit type arguments
+		"(Map s double)":                    "Map(s, double)",
+		"((index Map int string) s double)": "Map[int, string](s, double)",
+		"(. a b c)":                         "a.b.c",
+		"(. (foo) bar)":                     "foo().bar",
+		"((. (. (foo) bar) baz) x)":         "foo().bar.baz(x)",
+		"(. ((. b Build)) Name)":            "b.Build().Name",
+		"((. obj m) x)":                     "obj.m(x)",
+		`(fmt.Println "n:" (len s) p.X)`:    `fmt.Println("n:", len(s), p.X)`,
+		`(fmt.Println "%d" (- n 1) 'c')`:    `fmt.Println("%d", n - 1, 'c')`,
+		// parentheses only where precedence needs them
+		"(+ a (* b c))":     "a + b * c",
+		"(* (+ a b) c)":     "(a + b) * c",
+		"(- (- a b) c)":     "a - b - c",
+		"(- a (- b c))":     "a - (b - c)",
+		"(|| (&& a b) c)":   "a && b || c",
+		"(&& (|| a b) c)":   "(a || b) && c",
+		"(- (- x))":         "-(-x)",
+		"(! (== a b))":      "!(a == b)",
+		"(. (+ a b) x)":     "(a + b).x",
+		"((* f) x)":         "(*f)(x)",
+		"(index (+ a b) i)": "(a + b)[i]",
+		// string and rune literals
+		`(f "a \"b\" c" 'x')`:          `f("a \"b\" c", 'x')`,
+		"(f `a \\d (b)`)":              "f(`a \\d (b)`)",
+		"(f \"two\nlines\")":           `f("two\nlines")`,
+		`(f '\'' '\x41' '\u00e9' 'é')`: `f('\'', '\x41', '\u00e9', 'é')`,
 	}
 	log := ""
 	defer func() {
@@ -187,3 +241,478 @@ func TestNodeProcessValue(t *testing.T) {
 	t.Logf("Failed %v/%v NodeProcessValue() tests.", failed, len(cases))
 	log = ""
 }
+
+func TestConvertString(t *testing.T) {
+	got, err := ConvertString(`(package main) (func main () () (println "hi"))`)
+	want := "package main\n\nfunc main() {\n\tprintln(\"hi\")\n}\n"
+	if err != nil || got != want {
+		t.Errorf("Got %q and error %v instead of %q", got, err, want)
+	}
+	// invalid Golid gives an error instead of panicking
+	if got, err := ConvertString(`(package main) (bogus)`); err == nil {
+		t.Errorf("Converting invalid Golid gave no error, with result:\n%s", got)
+	}
+}
+
+func TestParseFile(t *testing.T) {
+	for in, forms := range map[string]int{
+		"(package p)": 1,
+		"(package p) (import \"fmt\") (import \"os\") (func f () ()) (func g () ())": 5,
+		"((package p) (func f () ()))":                       2,
+		"(generated) (go:build linux) (package p) (var x 1)": 4,
+		"package p\n\nfunc f () ()\n\tg\n":                   2,
+	} {
+		root, err := ParseFile(in)
+		if err != nil {
+			t.Errorf("Parsing %q gave error %v", in, err)
+			continue
+		}
+		n := 0
+		for top := root.First(); top != nil; top = top.Next() {
+			n++
+		}
+		if n != forms || root.First().First().Content() == "" {
+			t.Errorf("Parsing %q gave %d top-level forms instead of %d: %v", in, n, forms, root)
+		}
+	}
+	if got, err := ConvertString("(package p)"); err != nil || got != "package p\n" {
+		t.Errorf("Converting a lone package declaration gave %q and error %v", got, err)
+	}
+	for in, want := range map[string]string{
+		"":                             "Missing package declaration",
+		"(func f () ())":               "line 1, column 1: Expected the package declaration before",
+		"(func f () ()) (package p)":   "line 1, column 1: Expected the package declaration before",
+		"(package p)\n(package q)":     "line 2, column 1: There can only be one package declaration",
+		"(import \"fmt\") (package p)": "Expected the package declaration before",
+		"(package p)\n(f x))":          "line 2, column 6: Unmatched ')'",
+		"(package p)\n(func f () ()":   "line 2, column 1: Unclosed '('",
+	} {
+		if _, err := ConvertString(in); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Converting %q gave error %v instead of one containing %q", in, err, want)
+		}
+	}
+}
+
+func TestTranspile(t *testing.T) {
+	want, err := ConvertString(`(package main) (func main () () (println "hi"))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := Transpile(`(package main) (func main () () (println "hi"))`); err != nil || got != want {
+		t.Errorf("Got %q and error %v instead of %q", got, err, want)
+	}
+	got, err := TranspileFile("../tests/hello_world/classic.gol")
+	if err != nil || !strings.Contains(got, `fmt.Println("Hello Golid!")`) {
+		t.Errorf("Transpiling a file gave:\n%s\nand error %v", got, err)
+	}
+	if _, err := TranspileFile("../tests/hello_world/output.go"); err == nil {
+		t.Errorf("Transpiling a non-Golid file gave no error")
+	}
+}
+
+// Check that the canonical Hello World program transpiles to exactly
+// the expected Go, and that the Go is formatted and type checks.
+func TestHelloWorld(t *testing.T) {
+	src := `(package main) (import "fmt") (func main () () (fmt.Println "Hello, World!"))`
+	want := "package main\n\nimport (\n\t\"fmt\"\n)\n\nfunc main() {\n\tfmt.Println(\"Hello, World!\")\n}\n"
+	got, err := Transpile(src, Indent())
+	if err != nil || got != want {
+		t.Fatalf("Got %q and error %v instead of %q", got, err, want)
+	}
+	if formatted, err := format.Source([]byte(got)); err != nil || string(formatted) != got {
+		t.Errorf("Output isn't gofmt-formatted: %q, error %v", formatted, err)
+	}
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "main.go", got, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := types.Config{Importer: importer.Default()}
+	if _, err := conf.Check("main", fset, []*ast.File{file}, nil); err != nil {
+		t.Errorf("Output doesn't type check: %v", err)
+	}
+}
+
+func TestToGoAST(t *testing.T) {
+	src := "(package main) (import \"fmt\")\n; main prints six.\n(func main () () (:= x (* 2 (+ 1 2))) (fmt.Println x))"
+	root, err := ParseFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fset, file, err := root.ToGoAST()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.Name.Name != "main" || len(file.Decls) != 2 || len(file.Comments) != 1 {
+		t.Fatalf("Got %#v instead of a file with two declarations and a comment in package main", file)
+	}
+	var printed bytes.Buffer
+	if err := printer.Fprint(&printed, fset, file); err != nil {
+		t.Fatal(err)
+	}
+	if want, _ := root.FormatGo(); printed.String() != want {
+		t.Errorf("Printing the tree gave %q instead of %q", printed.String(), want)
+	}
+	for _, in := range []string{"(package p) (func f () () (raw \"x :=\"))", "(package p) (func)"} {
+		root, err := ParseFile(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fset, file, err := root.ToGoAST(); err == nil || fset != nil || file != nil {
+			t.Errorf("Converting %q gave %v and error %v", in, file, err)
+		}
+	}
+}
+
+// Check that arbitrary input gives Go or an error from Transpile,
+// without panicking or running into runtime errors like nil pointer
+// dereferences, which would mean a handler is missing a check.
+func FuzzTranspile(f *testing.F) {
+	f.Add(`(package main) (import "fmt") (func main () () (fmt.Println "Hello, World!"))`)
+	f.Add("(package p) (func f ((x int)) (int) ((if (< x 0) ((return (- x)))) (return x)))")
+	f.Add("(package p) (type T (struct (a int) (b (slice (* T)))))")
+	f.Add("(package p) (var ((a 1) (b (map string int))))")
+	f.Add("(package p) (func f () () ((for (range k v m) ((switch k (case 1 (g v)))))))")
+	f.Add("(package")
+	f.Add("(()) ((")
+	f.Add("))00")
+	f.Add("(;0")
+	f.Add("(package p) (func f () () x)")
+	f.Add("(package p) (import ())")
+	f.Add("package 0)(func 00()()(0)(switch (case")
+	f.Add("(package p) (func f () () (switch x (case)))")
+	f.Add("(package p) (func f () () (= () 1))")
+	f.Add("(package p) (func f () () (type-switch ()))")
+	f.Add("(package p) (func f () () (for ((:= i 0))))")
+	f.Add("(package p) (type I (interface (String ())))")
+	f.Add("(package p) (assert-implements () I)")
+	f.Fuzz(func(t *testing.T, src string) {
+		_, err := Transpile(src, Indent())
+		var runtimeErr runtime.Error
+		if errors.As(err, &runtimeErr) {
+			t.Errorf("Transpiling %q gave runtime error: %v", src, err)
+		}
+	})
+}
+
+// Check that the Indent option indents Go that can't be formatted by
+// how deeply it's nested, leaving strings alone.
+func TestIndent(t *testing.T) {
+	src := "(package p)\n(func f () () ((if x ((raw \"y :=\")) ((switch z (case 1 (g \"{\" `a\n  b(`)) (default (h)))))))\n"
+	want := "package p\n\nfunc f() {\n\tif x {\n\t\ty :=\n\t} else {\n\t\tswitch z {\n\t\tcase 1:\n\t\t\tg(\"{\", `a\n  b(`)\n\t\tdefault:\n\t\t\th()\n\t\t}\n\t}\n}\n"
+	got, err := Transpile(src, Indent())
+	if err == nil || got != want {
+		t.Errorf("Got %q and error %v instead of %q and a formatting error", got, err, want)
+	}
+	// without the option, the same Go comes back as it was generated
+	unindented, err := Transpile(src)
+	if err == nil || unindented == want || strings.Join(strings.Fields(unindented), " ") != strings.Join(strings.Fields(want), " ") {
+		t.Errorf("Got %q and error %v without indenting", unindented, err)
+	}
+	// valid Go is formatted either way
+	if got, err := Transpile(`(package p) (func f () () ((if x ((g)))))`, Indent()); err != nil || got != "package p\n\nfunc f() {\n\tif x {\n\t\tg()\n\t}\n}\n" {
+		t.Errorf("Got %q and error %v for valid Go", got, err)
+	}
+}
+
+// Check that printing parsed Golid gives Golid that parses the same.
+func TestNodeString(t *testing.T) {
+	cases := []string{
+		`(package main)`,
+		`(func main () () (:= x (+ 1 2)) (fmt.Println "x:" x 'c'))`,
+		`(if (< a b) ((f a) (g (h b))) ((return)))`,
+		`(slice (struct (name string)) ("a") ("b"))`,
+	}
+	for _, in := range cases {
+		first, err := parseRoot(in)
+		if err != nil {
+			t.Fatalf("Could not parse '%s': %v", in, err)
+		}
+		// ".first" skips the root Node added by parseRoot()
+		printed := first.first.String()
+		second, err := parseRoot(printed)
+		if err != nil {
+			t.Errorf("Could not reparse '%s' printed as:\n%s\n%v", in, printed, err)
+			continue
+		}
+		if second.first.String() != printed {
+			t.Errorf("Printing '%s' gave:\n%s\nwhich reprinted as:\n%s", in, printed, second.first.String())
+		}
+	}
+}
+
+func TestWalk(t *testing.T) {
+	root, err := parseRoot(`(f (g x) (h (k y)) z)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	visited := []string{}
+	root.first.Walk(func(n *Node) bool {
+		if n.first == nil {
+			visited = append(visited, n.content)
+		}
+		// skip everything under "(h ...)"
+		return n.first == nil || n.first.content != "h"
+	})
+	got := strings.Join(visited, " ")
+	want := "f g x z"
+	if got != want {
+		t.Errorf("Walk visited '%s' instead of '%s'", got, want)
+	}
+}
+
+func TestNewNode(t *testing.T) {
+	tok := func(s string) *Node { return NewNode(s) }
+	root := NewNode("",
+		NewNode("", tok("package"), tok("main")),
+		NewNode("", tok("func"), tok("main"), NewNode(""), NewNode(""),
+			NewNode("", tok("println"), NewNode("", tok("+"), tok("1"), tok("2")))))
+	got, err := root.FormatGo()
+	want := "package main\n\nfunc main() {\n\tprintln(1 + 2)\n}\n"
+	if err != nil || got != want {
+		t.Errorf("Got %q and error %v instead of %q", got, err, want)
+	}
+	if call := root.First().Next().First(); call.Content() != "func" || call.Parent().First() != call {
+		t.Errorf("Built tree isn't linked right: %v", root)
+	}
+}
+
+// Check that trees with cycles in their lists give errors instead of
+// hanging.
+func TestNodeCycle(t *testing.T) {
+	tok := func(s string) *Node { return NewNode(s) }
+	pkg := NewNode("", tok("package"), tok("main"))
+	fn := NewNode("", tok("func"), tok("main"), NewNode(""), NewNode(""))
+	call := NewNode("", tok("f"))
+	body := NewNode("", call, NewNode("", tok("g")))
+	body.last.next = call // the body's statements loop back around
+	for name, root := range map[string]*Node{
+		"top-level": NewNode("", pkg, fn, pkg),
+		"nested":    NewNode("", NewNode("", tok("package"), tok("main")), NewNode("", tok("func"), tok("main"), NewNode(""), NewNode(""), body)),
+	} {
+		var out bytes.Buffer
+		if err := root.WriteGo(&out); err == nil || !strings.Contains(err.Error(), "Cycle detected in node list") {
+			t.Errorf("Converting a tree with a %s cycle gave error %v", name, err)
+		}
+		if _, _, err := root.ToGoAST(); err == nil || !strings.Contains(err.Error(), "Cycle detected in node list") {
+			t.Errorf("Converting a tree with a %s cycle to an AST gave error %v", name, err)
+		}
+	}
+}
+
+func TestErrorPosition(t *testing.T) {
+	cases := map[string]string{
+		"(package main)\n\n(bogus)":                               "line 3, column 1: ",
+		"(package main)\n(func main () ()\n\t(f)\n\t(for x (g)))": "line 4, column 2: ",
+		"(package main)\n\nfunc main () ()\n\tfor x\n\t\tg\n":     "line 4, column 2: ",
+	}
+	for in, want := range cases {
+		_, err := ConvertString(in)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Converting %q gave error:\n%v\ninstead of one containing '%s'", in, err, want)
+		}
+	}
+	root, err := parseRoot("(f\n  (g x))")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line, col := root.first.first.next.Position(); line != 2 || col != 3 {
+		t.Errorf("Got position %d, %d instead of 2, 3", line, col)
+	}
+}
+
+func TestInvalidLiterals(t *testing.T) {
+	cases := map[string]string{
+		`(package main) (var s "\q")`:   "Invalid string literal",
+		`(package main) (var r 'ab')`:   "Could not find end of token",
+		`(package main) (var r '\xZZ')`: "Invalid rune literal",
+		`(package main) (var n 1a2)`:    "Invalid number literal",
+		`(package main) (var n 0x)`:     "Invalid number literal",
+	}
+	for in, want := range cases {
+		_, err := ConvertString(in)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Converting %q gave error:\n%v\ninstead of one containing '%s'", in, err, want)
+		}
+	}
+}
+
+func TestUnknownNodeError(t *testing.T) {
+	cases := map[string]string{
+		"(package main)\n(bogus x)":                            "top-level",
+		"(package main)\n(var x (weird int) 1)":                "type",
+		"(package main)\n(func f () () ((:= m (make (bad)))))": "type",
+		"(package p) (func f () () x)":                         "action",
+	}
+	for in, context := range cases {
+		_, err := ConvertString(in)
+		var unknown *UnknownNodeError
+		if !errors.As(err, &unknown) {
+			t.Errorf("Converting %q gave error %v instead of an UnknownNodeError", in, err)
+		} else if unknown.Context != context {
+			t.Errorf("Converting %q gave an unknown %s node instead of %s", in, unknown.Context, context)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	cases := map[string]string{
+		"; Command hi says hi.\n(package main)\n\n;; main is where it starts\n(func main () ()\n  ; greet\n  (println \"hi\"))\n": "// Command hi says hi.\npackage main\n\n// main is where it starts\nfunc main() {\n\t// greet\n\tprintln(\"hi\")\n}\n",
+		"package main\n\n; main is where it starts\nfunc main () ()\n\t; greet\n\tprintln \"hi\"\n":                               "package main\n\n// main is where it starts\nfunc main() {\n\t// greet\n\tprintln(\"hi\")\n}\n",
+	}
+	for in, want := range cases {
+		got, err := ConvertString(in)
+		if err != nil || got != want {
+			t.Errorf("Converting %q gave:\n%s\nand error %v instead of:\n%s", in, got, err, want)
+		}
+	}
+}
+
+// Check that converting Go into Golid and back gives the same Go, and
+// that doing it again is stable.
+func TestFromGo(t *testing.T) {
+	cases := []string{
+		`package main
+
+import (
+	"fmt"
+	str "strings"
+)
+
+const (
+	A = iota
+	B
+	Pi float64 = 3.14
+)
+
+var names []string
+
+type Point struct {
+	X, Y int
+	fmt.Stringer
+}
+
+type Shape interface {
+	Area() float64
+}
+
+func (p *Point) Move(dx, dy int) {
+	p.X += dx
+	p.Y++
+}
+
+func fib(n int) int {
+	if n < 2 {
+		return n
+	}
+	return fib(n-1) + fib(n-2)
+}
+
+func main() {
+	const limit = 5
+	var total int = 0
+	x, err := f(1, "two", 'c')
+	if err != nil {
+		panic(err)
+	} else if x > 0 && !ok {
+		x = -x
+	} else {
+		defer done()
+	}
+	for i := 0; i < 10; i++ {
+		if i%2 == 0 {
+			continue
+		}
+		fmt.Println(str.Repeat("x", i), p.X, (a + b) * c)
+	}
+	for k, v := range m {
+		go work(k, v...)
+	}
+	for {
+		break
+	}
+	for i := range 3 {
+		fmt.Println(i)
+	}
+	for f(x) {
+		ch <- <-other
+	}
+	switch y := g(); y {
+	case 1, 2:
+		h()
+	default:
+		fmt.Println(s[1:], s[:n], s[i:j:k], m["a"], &Point{X: 1, Y: 2})
+	}
+	fn := func(a int) (int, error) { return a * 2, nil }
+	v := r.(io.Reader)
+	xs := []int{1, 2, 3}
+	ms := map[string]int{"a": 1}
+	c := make(chan<- int, 5)
+	q := new(Point)
+	q.Move(1, 2)
+	{
+		q := 3
+		q++
+	}
+	fn(len(xs), len(ms), v, c, []byte("hi"), float64(total))
+	fmt.Println(xs[1:]...)
+	if n, ok := ms["a"]; ok {
+		fmt.Println(n)
+	}
+}
+`,
+		"package p\n\ntype T struct {\n\tX, Y int `json:\"x\"`\n\tName string `json:\"name,omitempty\" db:\"name\"`\n}\n",
+		`package generic
+
+type Stack[T any] struct {
+	items []T
+}
+
+func Map[T, U any](s []T, f func(T) U) []U {
+	out := make([]U, 0, len(s))
+	for _, v := range s {
+		out = append(out, f(v))
+	}
+	return out
+}
+`,
+	}
+	for _, src := range cases {
+		want, err := gofmtWrapped("", src, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		root, err := FromGo(src)
+		if err != nil {
+			t.Errorf("Could not convert Go into Golid: %v\n%s", err, src)
+			continue
+		}
+		got, err := root.FormatGo()
+		if err == nil {
+			// ignore differences in line breaks
+			got, err = gofmtWrapped("", got, "")
+		}
+		if err != nil || got != want {
+			t.Errorf("Converting Go into Golid and back gave:\n%s\nand error %v instead of:\n%s", got, err, want)
+			continue
+		}
+		// the printed Golid also converts back into the same Go
+		again, err := ConvertString(root.String())
+		if err == nil {
+			again, err = gofmtWrapped("", again, "")
+		}
+		if err != nil || again != got {
+			t.Errorf("Converting the printed Golid:\n%v\ngave:\n%s\nand error %v", root, again, err)
+		}
+	}
+	for _, src := range []string{
+		"package p\nfunc f() { type T int }",
+		"package p\nvar a, b = 1, 2",
+		"package p\nvar s = [][]int{{1}}",
+		"package p\nvar p Point",
+	} {
+		if root, err := FromGo(src); err == nil {
+			t.Errorf("Converting unsupported Go:\n%s\ngave:\n%v", src, root)
+		}
+	}
+}
@@ -2,7 +2,11 @@
 
 package parse
 
-import "strings"
+import (
+	"io"
+	"strconv"
+	"strings"
+)
 
 // An Expression represents a parsed Lisp expression, which is either
 // a list of Expressions or an Atom. This interface attempts to unify
@@ -15,6 +19,13 @@ type Expression interface {
 	// Convert (one-way?) to Go form. It doesn't have to be pretty. It
 	// just has to compile if the input code is valid piklisp-go.
 	GoString() string
+
+	// Write the same Go as GoString to w, a piece at a time.
+	WriteGo(w io.Writer) error
+
+	// Convert to gofmt-formatted Go form, or return the unformatted Go
+	// and an error if it doesn't parse.
+	FormatGo() (string, error)
 }
 
 // A Node represents a single thing in parsing a Lisp expression.
@@ -23,6 +34,8 @@ type Node struct {
 	next        *Node // the next Node under this Node's parent
 	first, last *Node // the first and last child Nodes of this one
 	content     string
+	line, col   int      // where the Node starts in its source, or 0 if unknown
+	comments    []string // text of the comment lines just before the Node
 }
 
 // Make a root node.
@@ -49,9 +62,58 @@ func (n *Node) AddToken(s string) {
 	n.last.content = s
 }
 
+// Make a Node with the given content and children, for building trees
+// without parsing. Tokens have content and no children, while lists
+// have children and no content, so "(f x)" is NewNode("",
+// NewNode("f"), NewNode("x")).
+func NewNode(content string, children ...*Node) *Node {
+	n := &Node{content: content}
+	for _, child := range children {
+		if n.first == nil {
+			n.first = child
+		} else {
+			n.last.next = child
+		}
+		n.last = child
+		child.parent = n
+	}
+	return n
+}
+
 // Accessor needed for parser
 func (n *Node) Parent() *Node { return n.parent }
 
+// Return the line and column where the Node starts in its source,
+// counting from 1, or 0 and 0 if it wasn't parsed from source.
+func (n *Node) Position() (line, col int) { return n.line, n.col }
+
+// Describe where the Node starts in its source, like "line 12, column
+// 3: ", for prefixing error messages, or "" if the position isn't known.
+func (n *Node) positionPrefix() string {
+	if n.line == 0 {
+		return ""
+	}
+	return "line " + strconv.Itoa(n.line) + ", column " + strconv.Itoa(n.col) + ": "
+}
+
+// Accessors for walking trees outside this package
+func (n *Node) Content() string { return n.content }
+func (n *Node) First() *Node    { return n.first }
+func (n *Node) Next() *Node     { return n.next }
+
+// Visit n and then every Node under it, in depth-first pre-order, so
+// each Node is visited before its children and its children are
+// visited in order. If fn returns false for a Node, then its children
+// are skipped. Nodes after n under its parent aren't visited.
+func (n *Node) Walk(fn func(*Node) bool) {
+	if !fn(n) {
+		return
+	}
+	for child := n.first; child != nil; child = child.next {
+		child.Walk(fn)
+	}
+}
+
 // Indent every line with a leading tab.
 func indent(s string) string {
 	ret := ""
@@ -0,0 +1,565 @@
+// convert Go source back into a Golid syntax tree
+
+package parse
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+)
+
+// Parse Go source into an equivalent Golid syntax tree, so that its
+// GoString() is the same program. Only the constructs that GoString()
+// itself emits are handled, and anything else gives an error. The
+// returned Node is a root, like what parsing Golid gives.
+func FromGo(src string) (root *Node, err error) {
+	file, err := parser.ParseFile(token.NewFileSet(), "", src, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			root, err = nil, fmt.Errorf("%v", r)
+		}
+	}()
+	root = Root()
+	appendChild(root, list(tok("package"), tok(file.Name.Name)))
+	for _, decl := range file.Decls {
+		appendChild(root, goDecl(decl))
+	}
+	return root, nil
+}
+
+// Make a token Node.
+func tok(s string) *Node { return NewNode(s) }
+
+// Make a list Node.
+func list(children ...*Node) *Node { return NewNode("", children...) }
+
+// Add child after the current last child of n.
+func appendChild(n *Node, child *Node) {
+	if n.first == nil {
+		n.first = child
+	} else {
+		n.last.next = child
+	}
+	n.last = child
+	child.parent = n
+}
+
+// Panic about a Go construct that can't be converted into Golid.
+func unsupportedGo(what string, node ast.Node) {
+	panic(fmt.Sprintf("Unsupported Go %s: %T", what, node))
+}
+
+// Convert a top-level Go declaration into Golid.
+func goDecl(decl ast.Decl) *Node {
+	switch d := decl.(type) {
+	case *ast.FuncDecl:
+		out := list(tok("func"))
+		if d.Recv != nil {
+			appendChild(out, goFields(d.Recv))
+		}
+		appendChild(out, tok(d.Name.Name))
+		if d.Type.TypeParams != nil {
+			appendChild(out, goFields(d.Type.TypeParams))
+		}
+		appendChild(out, goFields(d.Type.Params))
+		appendChild(out, goFields(d.Type.Results))
+		appendChild(out, goBlock(d.Body))
+		return out
+	case *ast.GenDecl:
+		return goGenDecl(d)
+	}
+	unsupportedGo("declaration", decl)
+	return nil
+}
+
+// Convert an import, const, var or type declaration into Golid.
+func goGenDecl(d *ast.GenDecl) *Node {
+	out := list(tok(d.Tok.String()))
+	for _, spec := range d.Specs {
+		switch s := spec.(type) {
+		case *ast.ImportSpec:
+			if s.Name == nil {
+				appendChild(out, tok(s.Path.Value))
+			} else {
+				appendChild(out, list(tok(s.Name.Name), tok(s.Path.Value)))
+			}
+		case *ast.ValueSpec:
+			if !d.Lparen.IsValid() { // "(var x 5)" instead of "(var (x 5))"
+				goValueSpec(out, s)
+				return out
+			}
+			entry := list()
+			goValueSpec(entry, s)
+			appendChild(out, entry)
+		case *ast.TypeSpec:
+			if s.Assign.IsValid() || len(d.Specs) != 1 {
+				unsupportedGo("type declaration", s)
+			}
+			appendChild(out, tok(s.Name.Name))
+			if s.TypeParams != nil {
+				appendChild(out, goFields(s.TypeParams))
+			}
+			appendChild(out, goType(s.Type))
+		}
+	}
+	return out
+}
+
+// Convert a const or var spec like "a, b int = 0" into Golid like "a b
+// int 0", adding it to the end of out. A type without a value has to
+// look like a type, since "(x T)" would otherwise be "x = T".
+func goValueSpec(out *Node, s *ast.ValueSpec) {
+	if len(s.Values) > 1 || len(s.Names) > 1 && (s.Type == nil || len(s.Values) == 0) {
+		unsupportedGo("multi-value spec", s)
+	}
+	if s.Type != nil && len(s.Values) == 0 && !nu_is_type_name(goType(s.Type)) {
+		unsupportedGo("var type", s.Type)
+	}
+	for _, name := range s.Names {
+		appendChild(out, tok(name.Name))
+	}
+	if s.Type != nil {
+		appendChild(out, goType(s.Type))
+	}
+	for _, v := range s.Values {
+		appendChild(out, goExpr(v))
+	}
+}
+
+// Convert a list of parameters, results, or receivers into a Golid
+// list of field entries, like "((a b int) (error))".
+func goFields(fields *ast.FieldList) *Node {
+	out := list()
+	if fields == nil {
+		return out
+	}
+	for _, field := range fields.List {
+		appendChild(out, goField(field))
+	}
+	return out
+}
+
+// Convert a field like "a, b int" into a Golid entry like "(a b int)",
+// with any struct tag at the end.
+func goField(field *ast.Field) *Node {
+	out := list()
+	for _, name := range field.Names {
+		appendChild(out, tok(name.Name))
+	}
+	if ellipsis, ok := field.Type.(*ast.Ellipsis); ok {
+		elem := goType(ellipsis.Elt)
+		if elem.content == "" {
+			unsupportedGo("variadic parameter", ellipsis)
+		}
+		appendChild(out, tok("..."+elem.content))
+	} else {
+		appendChild(out, goType(field.Type))
+	}
+	if field.Tag != nil {
+		appendChild(out, tok(field.Tag.Value))
+	}
+	return out
+}
+
+// Convert a Go type expression into Golid.
+func goType(expr ast.Expr) *Node {
+	switch t := expr.(type) {
+	case *ast.Ident:
+		return tok(t.Name)
+	case *ast.SelectorExpr:
+		if x, ok := t.X.(*ast.Ident); ok {
+			return tok(x.Name + "." + t.Sel.Name)
+		}
+	case *ast.ParenExpr:
+		return goType(t.X)
+	case *ast.StarExpr:
+		return list(tok("*"), goType(t.X))
+	case *ast.ArrayType:
+		if t.Len == nil {
+			return list(tok("slice"), goType(t.Elt))
+		}
+		return list(tok("array"), goExpr(t.Len), goType(t.Elt))
+	case *ast.MapType:
+		return list(tok("map"), goType(t.Key), goType(t.Value))
+	case *ast.ChanType:
+		kw := map[ast.ChanDir]string{ast.SEND | ast.RECV: "chan", ast.SEND: "chan-send", ast.RECV: "chan-recv"}[t.Dir]
+		return list(tok(kw), goType(t.Value))
+	case *ast.FuncType:
+		return list(tok("func-type"), goFields(t.Params), goFields(t.Results))
+	case *ast.StructType:
+		out := list(tok("struct"))
+		for _, field := range t.Fields.List {
+			appendChild(out, goField(field))
+		}
+		return out
+	case *ast.InterfaceType:
+		out := list(tok("interface"))
+		for _, field := range t.Methods.List {
+			if len(field.Names) == 0 {
+				appendChild(out, goType(field.Type))
+				continue
+			}
+			fn := field.Type.(*ast.FuncType)
+			appendChild(out, list(tok(field.Names[0].Name), goFields(fn.Params), goFields(fn.Results)))
+		}
+		return out
+	}
+	unsupportedGo("type", expr)
+	return nil
+}
+
+// Convert a Go expression into Golid.
+func goExpr(expr ast.Expr) *Node {
+	switch e := expr.(type) {
+	case *ast.Ident:
+		return tok(e.Name)
+	case *ast.BasicLit:
+		return tok(e.Value)
+	case *ast.ParenExpr:
+		return goExpr(e.X)
+	case *ast.BinaryExpr:
+		return list(tok(e.Op.String()), goExpr(e.X), goExpr(e.Y))
+	case *ast.UnaryExpr:
+		return list(tok(e.Op.String()), goExpr(e.X))
+	case *ast.StarExpr:
+		return list(tok("*"), goExpr(e.X))
+	case *ast.SelectorExpr:
+		x := goExpr(e.X)
+		if _, ok := e.X.(*ast.BasicLit); !ok && x.content != "" {
+			return tok(x.content + "." + e.Sel.Name)
+		}
+		if x.first != nil && x.first.content == "." {
+			appendChild(x, tok(e.Sel.Name))
+			return x
+		}
+		return list(tok("."), x, tok(e.Sel.Name))
+	case *ast.CallExpr:
+		return goCall(e)
+	case *ast.IndexExpr:
+		return list(tok("index"), goExpr(e.X), goExpr(e.Index))
+	case *ast.SliceExpr:
+		out := list(tok("slice-expr"), goExpr(e.X))
+		bounds := []ast.Expr{e.Low, e.High}
+		if e.Slice3 {
+			bounds = append(bounds, e.Max)
+		}
+		for len(bounds) > 1 && bounds[len(bounds)-1] == nil {
+			bounds = bounds[:len(bounds)-1]
+		}
+		for _, bound := range bounds {
+			if bound == nil {
+				appendChild(out, list())
+			} else {
+				appendChild(out, goExpr(bound))
+			}
+		}
+		return out
+	case *ast.TypeAssertExpr:
+		return list(tok("assert"), goExpr(e.X), goType(e.Type))
+	case *ast.CompositeLit:
+		return goCompositeLit(e)
+	case *ast.FuncLit:
+		return list(tok("func"), goFields(e.Type.Params), goFields(e.Type.Results), goBlock(e.Body))
+	}
+	unsupportedGo("expression", expr)
+	return nil
+}
+
+// Convert a function call into Golid, using a type-first form for
+// calls to make and new, and a convert form for conversions into
+// types that aren't names.
+func goCall(e *ast.CallExpr) *Node {
+	switch e.Fun.(type) {
+	case *ast.ArrayType, *ast.MapType, *ast.ChanType, *ast.FuncType:
+		if len(e.Args) == 1 {
+			return list(tok("convert"), goType(e.Fun), goExpr(e.Args[0]))
+		}
+	}
+	fun := goExpr(e.Fun)
+	if fun.content == "make" || fun.content == "new" {
+		out := list(fun, goType(e.Args[0]))
+		for _, arg := range e.Args[1:] {
+			appendChild(out, goExpr(arg))
+		}
+		return out
+	}
+	out := list(fun)
+	for _, arg := range e.Args {
+		appendChild(out, goExpr(arg))
+	}
+	if e.Ellipsis.IsValid() && out.last.content == "" {
+		appendChild(out, tok("..."))
+	} else if e.Ellipsis.IsValid() {
+		out.last.content += "..."
+	}
+	return out
+}
+
+// Convert a slice, map or struct literal into Golid.
+func goCompositeLit(e *ast.CompositeLit) *Node {
+	var out *Node
+	switch t := e.Type.(type) {
+	case *ast.ArrayType:
+		if t.Len != nil {
+			unsupportedGo("array literal", e)
+		}
+		out = list(tok("slice"), goType(t.Elt))
+	case *ast.MapType:
+		out = list(tok("map"), goType(t.Key), goType(t.Value))
+	case *ast.Ident, *ast.SelectorExpr:
+		out = list(tok("new-struct"), goType(t))
+	default:
+		unsupportedGo("composite literal", e)
+	}
+	// Golid would take list elements of an elidable type like "(slice
+	// (slice int))" to be elided literals, and list elements of a struct
+	// to be keyed, so those have to be tokens.
+	tokensOnly := out.first.content == "new-struct" || nu_is_elidable(out.first.next)
+	for _, elt := range e.Elts {
+		switch kv := elt.(type) {
+		case *ast.KeyValueExpr:
+			if out.first.content == "slice" {
+				unsupportedGo("indexed element", kv)
+			}
+			appendChild(out, list(goExpr(kv.Key), goExpr(kv.Value)))
+		case *ast.CompositeLit:
+			unsupportedGo("nested composite literal", kv)
+		default:
+			value := goExpr(kv)
+			if out.first.content == "map" || tokensOnly && value.content == "" {
+				unsupportedGo("positional element", kv)
+			}
+			appendChild(out, value)
+		}
+	}
+	return out
+}
+
+// Convert a Go block into a Golid block: a list of actions.
+func goBlock(block *ast.BlockStmt) *Node {
+	out := list()
+	for _, stmt := range block.List {
+		appendChild(out, goStmt(stmt))
+	}
+	return out
+}
+
+// Convert a Go statement into Golid.
+func goStmt(stmt ast.Stmt) *Node {
+	switch s := stmt.(type) {
+	case *ast.ExprStmt:
+		return goExpr(s.X)
+	case *ast.AssignStmt:
+		out := list(tok(s.Tok.String()), goTargets(s.Lhs))
+		for _, v := range s.Rhs {
+			appendChild(out, goExpr(v))
+		}
+		return out
+	case *ast.IncDecStmt:
+		return list(tok(s.Tok.String()), goExpr(s.X))
+	case *ast.SendStmt:
+		return list(tok("<-"), goExpr(s.Chan), goExpr(s.Value))
+	case *ast.ReturnStmt:
+		out := list(tok("return"))
+		for _, v := range s.Results {
+			appendChild(out, goExpr(v))
+		}
+		return out
+	case *ast.BlockStmt:
+		out := list(tok("block"))
+		for _, stmt := range s.List {
+			appendChild(out, goStmt(stmt))
+		}
+		return out
+	case *ast.BranchStmt:
+		out := list(tok(s.Tok.String()))
+		if s.Label != nil {
+			appendChild(out, tok(s.Label.Name))
+		}
+		return out
+	case *ast.LabeledStmt:
+		if _, ok := s.Stmt.(*ast.EmptyStmt); ok {
+			return list(tok("label"), tok(s.Label.Name))
+		}
+		return list(tok("label"), tok(s.Label.Name), goStmt(s.Stmt))
+	case *ast.GoStmt:
+		return list(tok("go"), goExpr(s.Call))
+	case *ast.DeferStmt:
+		return list(tok("defer"), goExpr(s.Call))
+	case *ast.IfStmt:
+		return goIf(s)
+	case *ast.ForStmt:
+		return goFor(s)
+	case *ast.RangeStmt:
+		return goRange(s)
+	case *ast.SwitchStmt:
+		return goSwitch(s)
+	case *ast.DeclStmt:
+		if d, ok := s.Decl.(*ast.GenDecl); ok && (d.Tok == token.CONST || d.Tok == token.VAR) {
+			return goGenDecl(d)
+		}
+	}
+	unsupportedGo("statement", stmt)
+	return nil
+}
+
+// Convert the left-hand side of an assignment into Golid, listing
+// several targets like "(a b)".
+func goTargets(lhs []ast.Expr) *Node {
+	if len(lhs) == 1 {
+		return goExpr(lhs[0])
+	}
+	out := list()
+	for _, target := range lhs {
+		appendChild(out, goExpr(target))
+	}
+	return out
+}
+
+// Convert an if statement into Golid. An "else if" chain without any
+// simple statements or empty blocks uses the "(if (cond body...) ...
+// (else body...))" form, and anything else uses the "(if [init] cond
+// (body...) [(else...)])" form, nesting any "else if" in the else
+// block.
+func goIf(s *ast.IfStmt) *Node {
+	if clauses := goIfClauses(s); clauses != nil {
+		return list(append([]*Node{tok("if")}, clauses...)...)
+	}
+	out := list(tok("if"))
+	if s.Init != nil {
+		appendChild(out, goStmt(s.Init))
+	}
+	appendChild(out, goExpr(s.Cond))
+	appendChild(out, goBlock(s.Body))
+	switch e := s.Else.(type) {
+	case *ast.BlockStmt:
+		appendChild(out, goBlock(e))
+	case *ast.IfStmt:
+		appendChild(out, list(goIf(e)))
+	}
+	return out
+}
+
+// Convert a for loop into Golid. Three-clause loops need a post
+// statement, and while-style loops need a condition that's a call or
+// operator expression, since those are what Golid can tell apart.
+func goFor(s *ast.ForStmt) *Node {
+	out := list(tok("for"))
+	switch {
+	case s.Init == nil && s.Cond == nil && s.Post == nil:
+		appendChild(out, list())
+	case s.Post != nil && s.Cond != nil:
+		cond := goExpr(s.Cond)
+		if s.Init == nil && !nu_is_condition(cond) {
+			unsupportedGo("loop condition", s.Cond)
+		}
+		if s.Init == nil {
+			appendChild(out, list())
+		} else {
+			appendChild(out, goStmt(s.Init))
+		}
+		appendChild(out, cond)
+		appendChild(out, goStmt(s.Post))
+	case s.Init == nil && s.Post == nil:
+		cond := goExpr(s.Cond)
+		if cond.first == nil || cond.first.content == "" {
+			unsupportedGo("loop condition", s.Cond)
+		}
+		appendChild(out, cond)
+	default:
+		unsupportedGo("for loop", s)
+	}
+	appendChild(out, goBlock(s.Body))
+	return out
+}
+
+// Convert a range loop into Golid's "(for (range k v x) (body...))"
+// form.
+func goRange(s *ast.RangeStmt) *Node {
+	kw := "range"
+	if s.Tok == token.ASSIGN {
+		kw = "range-assign"
+	}
+	header := list(tok(kw))
+	for _, v := range []ast.Expr{s.Key, s.Value} {
+		if v != nil {
+			target := goExpr(v)
+			if target.content == "" {
+				unsupportedGo("range variable", v)
+			}
+			appendChild(header, target)
+		}
+	}
+	appendChild(header, goExpr(s.X))
+	return list(tok("for"), header, goBlock(s.Body))
+}
+
+// Convert a switch statement into Golid's "(switch [init] [tag] (case
+// values... body...) ... (default body...))" form. A case's values
+// must be either all tokens or a single expression.
+func goSwitch(s *ast.SwitchStmt) *Node {
+	out := list(tok("switch"))
+	if s.Init != nil {
+		appendChild(out, goStmt(s.Init))
+	}
+	if s.Tag != nil {
+		appendChild(out, goExpr(s.Tag))
+	}
+	for _, stmt := range s.Body.List {
+		clause := stmt.(*ast.CaseClause)
+		c := list(tok("case"))
+		if clause.List == nil {
+			c.first.content = "default"
+		}
+		for _, v := range clause.List {
+			value := goExpr(v)
+			if value.content == "" && len(clause.List) > 1 {
+				unsupportedGo("case values", v)
+			}
+			appendChild(c, value)
+		}
+		for _, bodyStmt := range clause.Body {
+			appendChild(c, goStmt(bodyStmt))
+		}
+		appendChild(out, c)
+	}
+	return out
+}
+
+// Convert an "else if" chain into Golid clauses like "(cond body...)"
+// and "(else body...)", or return nil if it can't be written that way.
+func goIfClauses(s *ast.IfStmt) []*Node {
+	clauses := []*Node{}
+	for {
+		if s.Init != nil || len(s.Body.List) == 0 {
+			return nil
+		}
+		clause := list(goExpr(s.Cond))
+		for _, stmt := range s.Body.List {
+			appendChild(clause, goStmt(stmt))
+		}
+		clauses = append(clauses, clause)
+		switch e := s.Else.(type) {
+		case *ast.IfStmt:
+			s = e
+			continue
+		case *ast.BlockStmt:
+			if len(e.List) == 0 {
+				return nil
+			}
+			clause := list(tok("else"))
+			for _, stmt := range e.List {
+				appendChild(clause, goStmt(stmt))
+			}
+			clauses = append(clauses, clause)
+		}
+		if len(clauses) < 2 {
+			return nil
+		}
+		return clauses
+	}
+}
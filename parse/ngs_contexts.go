@@ -6,9 +6,28 @@
 
 package parse
 
+// An UnknownNodeError is what's panicked with when a Node can't be
+// converted because it isn't a known form in its context. It can be
+// found in conversion errors with errors.As.
+type UnknownNodeError struct {
+	Context string // "top-level", "action", or "type"
+	Node    *Node  // the Node that couldn't be converted
+}
+
+func (e *UnknownNodeError) Error() string {
+	what := e.Node.String()
+	if e.Node.first != nil && e.Context == "top-level" {
+		what = e.Node.first.content
+	}
+	return e.Node.positionPrefix() + "Unknown " + e.Context + " node type: " + what
+}
+
 // Process a top-level Node
 func nc_top(n *Node) string {
 	first := n.first
+	if first == nil {
+		panic(&UnknownNodeError{"top-level", n})
+	}
 	var f func(*Node) string
 	switch first.content {
 	case "package":
@@ -19,31 +38,67 @@ func nc_top(n *Node) string {
 		f = nkw_var
 	case "func":
 		f = nkw_func
+	case "type":
+		f = nkw_type
+	case "assert-implements":
+		f = nkw_assert_implements
+	case "raw":
+		f = ns_raw
+	case "go:build":
+		f = nkw_build
+	case "generated":
+		f = nkw_generated
+	case "doc":
+		f = nkw_doc
 	default:
-		panic("Unknown top-level node type: " + first.content)
+		panic(&UnknownNodeError{"top-level", n})
 	}
 	return f(first)
 }
 
 // Process an action Node
 func nc_action(n *Node) string {
+	if nu_is_block(n) {
+		return nu_body(n.first)
+	}
 	first := n.first
+	if first == nil {
+		panic(&UnknownNodeError{"action", n})
+	}
 	var f func(*Node) string
 	switch first.content {
-	case "=", ":=", "+=", "-=", "*=", "/=", "++", "--":
+	case "=", ":=", "+=", "-=", "*=", "/=", "%=", "&=", "|=", "^=", "&^=", "<<=", ">>=", "++", "--":
 		f = ns_assign
 	case "if":
 		f = nkw_if
+	case "cond":
+		f = nkw_cond
 	case "for":
 		f = nkw_for
 	case "return":
 		f = nkw_return
 	case "switch":
 		f = nkw_switch
+	case "type-switch":
+		f = nkw_type_switch
 	case "select":
-		panic("nodeProcessAction: select not implemented")
-	case "break", "continue":
+		f = nkw_select
+	case "break", "continue", "fallthrough", "goto":
 		f = nkw_break
+	case "defer", "go":
+		f = nkw_defer
+	case "label":
+		f = nkw_label
+	case "block":
+		f = nkw_block
+	case "raw":
+		f = ns_raw
+	case "const", "var":
+		f = nkw_var
+	case "<-":
+		f = ns_chan_op
+	case "panic", "recover":
+		f = ns_panic_recover
 	default:
 		f = ns_funcall
 	}
@@ -55,16 +110,103 @@ func nc_value(n *Node) string {
 	if n == nil {
 		return ""
 	}
+	if n.content != "" {
+		return nu_literal(n)
+	}
+	first := n.first
+	if first == nil {
+		panic("Empty list instead of a value: \"" + n.String() + "\"!")
+	}
+	f := nc_value_func(first.content)
+	if f == nil && nu_is_predeclared_constant(first) {
+		f = ns_constant
+	} else if f == nil {
+		f = ns_funcall
+	}
+	return f(first)
+}
+
+// Find the function for processing a value Node whose first child has
+// the given content, or nil if the Node is a plain function call.
+func nc_value_func(content string) func(*Node) string {
+	switch content {
+	case "+", "-", "*", "/", "%", "&", "|", "^", "&^", "<<", ">>", "!", "&&", "||", "==", "!=", ">=", "<=", "<", ">":
+		return ns_math
+	case ".":
+		return ns_selector
+	case "index":
+		return ns_index
+	case "slice-expr":
+		return ns_slice_expr
+	case "<-":
+		return ns_chan_op
+	case "slice":
+		return ns_slice
+	case "array":
+		return ns_array
+	case "map":
+		return nkw_map
+	case "make", "new":
+		return ns_make
+	case "panic", "recover":
+		return ns_panic_recover
+	case "lambda", "func":
+		return ns_lambda
+	case "assert":
+		return ns_type_assert
+	case "convert":
+		return ns_convert
+	case "method-expr":
+		return ns_method_expr
+	case "raw":
+		return ns_raw
+	case "new-struct", "&struct":
+		return ns_new_struct
+	case "cond":
+		return nkw_cond_value
+	case "if-expr":
+		return nkw_if_expr
+	}
+	return nil
+}
+
+// Process a type Node
+func nc_type(n *Node) string {
 	if n.content != "" {
 		return n.content
 	}
 	first := n.first
+	if first == nil {
+		panic(&UnknownNodeError{"type", n})
+	}
 	var f func(*Node) string
 	switch first.content {
-	case "+", "-", "*", "/", "==", "!=", ">=", "<=", "<", ">":
-		f = ns_math
+	case "*":
+		f = ns_pointer_type
+	case "~":
+		f = ns_underlying_type
+	case "|":
+		f = nkw_union_type
+	case "slice":
+		f = ns_slice_type
+	case "array":
+		f = ns_array_type
+	case "map":
+		f = nkw_map_type
+	case "chan", "chan-send", "chan-recv":
+		f = nkw_chan_type
+	case "func", "func-type":
+		f = nkw_func_type
+	case "struct", "anon-struct":
+		f = nkw_struct_type
+	case "interface":
+		f = nkw_interface_type
+	case ".":
+		f = ns_selector
+	case "raw":
+		f = ns_raw
 	default:
-		f = ns_funcall
+		panic(&UnknownNodeError{"type", n})
 	}
 	return f(first)
 }
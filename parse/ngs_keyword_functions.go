@@ -6,43 +6,201 @@
 
 package parse
 
-// Convert Golid "(break)", "(break label)", "(continue)", and
-// "(continue label)" statements into Go.
-var nkw_break func(*Node) string = nu_raw_content_space
+import (
+	"go/token"
+	"sort"
+	"strings"
+)
 
-// Convert an import Node into a Go import command.
+// Convert Golid "(break)", "(break label)", "(continue)",
+// "(continue label)", "(goto label)", and "(fallthrough)" statements
+// into Go. A label can only be a single name, goto needs one, and
+// fallthrough can't have one.
+func nkw_break(keywordNode *Node) string {
+	label := keywordNode.next
+	switch {
+	case label == nil && keywordNode.content != "goto":
+		return keywordNode.content
+	case label != nil && label.next == nil && token.IsIdentifier(label.content) && keywordNode.content != "fallthrough":
+		return keywordNode.content + " " + label.content
+	}
+	panic("Invalid '" + keywordNode.content + "' statement: \"" + keywordNode.parent.String() + "\"!")
+}
+
+// Convert Golid "(defer (call args ...))" and "(go (call args ...))"
+// statements into Go.
+func nkw_defer(keywordNode *Node) string {
+	nu_require_args(keywordNode, 1, "a call")
+	return keywordNode.content + " " + nc_value(keywordNode.next)
+}
+
+// Convert a Golid "(block (:= x 1) (f x))" statement into a Go block
+// like "{ x := 1; f(x) }", for scoping variables.
+func nkw_block(keywordNode *Node) string {
+	return "{\n" + nu_body(keywordNode.next) + "}"
+}
+
+// Convert Golid "(label name)" and "(label name statement)" into Go.
+func nkw_label(keywordNode *Node) string {
+	nu_require_args(keywordNode, 1, "a label name")
+	n := keywordNode.next
+	if !token.IsIdentifier(n.content) || n.next != nil && n.next.next != nil {
+		panic("'label' takes a name and at most one statement: \"" + keywordNode.parent.String() + "\"!")
+	}
+	out := n.content + ":\n"
+	if n.next != nil {
+		out += nc_action(n.next)
+	}
+	return out
+}
+
+// Convert an import Node into a Go import command. Each import is
+// either a path like "\"fmt\"" or "(\"fmt\")", or an aliased path like
+// "(f \"fmt\")", "(. \"fmt\")", or "(_ \"db/driver\")". Imports are
+// sorted by path, with standard library packages first and a blank
+// line before the rest, so the output doesn't depend on the order
+// they're written in. Repeated imports are dropped, unless they have
+// different aliases, which is an error.
 func nkw_import(keywordNode *Node) string {
-	out := "import ("
+	if keywordNode.next == nil {
+		return ""
+	}
+	type spec struct{ alias, path, quoted string }
+	specs := []spec{}
+	aliases := map[string]string{}
 	for n := keywordNode.next; n != nil; n = n.next {
-		out += n.content + "; "
+		s := spec{}
+		pathNode := n
+		if n.first != nil && n.first.next == nil { // ("path")
+			pathNode = n.first
+		} else if n.first != nil && n.first.next.next == nil { // (alias "path")
+			s.alias, pathNode = n.first.content, n.first.next
+		}
+		if pathNode.content == "" || pathNode.content[0] != '"' && pathNode.content[0] != '`' {
+			panic("Invalid import: \"" + n.String() + "\"!")
+		}
+		s.path, s.quoted = nu_unquote(pathNode), pathNode.content
+		if alias, ok := aliases[s.path]; ok {
+			if alias != s.alias {
+				panic("Conflicting imports of \"" + s.path + "\": \"" + keywordNode.parent.String() + "\"!")
+			}
+			continue
+		}
+		aliases[s.path] = s.alias
+		specs = append(specs, s)
 	}
-	out += ")"
-	return out
+	isStd := func(path string) bool {
+		return !strings.Contains(strings.SplitN(path, "/", 2)[0], ".")
+	}
+	sort.SliceStable(specs, func(i, j int) bool {
+		if isStd(specs[i].path) != isStd(specs[j].path) {
+			return isStd(specs[i].path)
+		}
+		return specs[i].path < specs[j].path
+	})
+	out := "import (\n"
+	for i, s := range specs {
+		if i > 0 && isStd(specs[i-1].path) && !isStd(s.path) {
+			out += "\n"
+		}
+		if s.alias != "" {
+			out += s.alias + " "
+		}
+		out += s.quoted + "\n"
+	}
+	return out + ")"
 }
 
 // Convert a package declaration to Go.
 func nkw_package(keywordNode *Node) string {
+	nu_require_args(keywordNode, 1, "a package name")
 	return nu_raw_content(keywordNode, " ")
 }
 
-// Convert Golid "(myVar value)" and "(myVar type value)" expressions
-// (which are to the right of var (and const) expressions) into
-// corresponding Go "myVar = value" and "myVar type = vaule"
-// expressions.
-func nkw_var_post_kw(varNameNode *Node) string {
+// Convert a build constraint like "(go:build linux && amd64)" or
+// "(go:build \"linux && (386 || amd64)\")" into a "//go:build" line.
+// Constraints with parentheses need to be quoted. Like in Go, it must
+// come before the package declaration.
+func nkw_build(keywordNode *Node) string {
+	nu_require_args(keywordNode, 1, "a build constraint")
+	nu_require_before_package(keywordNode)
+	n := keywordNode.next
+	if !nu_all_atoms(n) {
+		panic("Build constraints with parentheses must be quoted: \"" + keywordNode.parent.String() + "\"!")
+	}
+	constraint := nu_raw_content_space(n)
+	if n.next == nil && strings.HasPrefix(n.content, "\"") {
+		constraint = nu_unquote(n)
+	}
+	return "//go:build " + constraint
+}
+
+// Convert "(generated)" into the standard comment marking the Go as
+// generated, so that tools know not to edit or lint it. A generator
+// name can be given, as in "(generated mytool)"; it defaults to
+// piklisp. Like a build constraint, it must come before the package
+// declaration.
+func nkw_generated(keywordNode *Node) string {
+	nu_require_before_package(keywordNode)
+	generator := "piklisp"
+	if n := keywordNode.next; n != nil {
+		generator = n.content
+		if strings.HasPrefix(n.content, "\"") {
+			generator = nu_unquote(n)
+		}
+	}
+	return "// Code generated by " + generator + "; DO NOT EDIT."
+}
+
+// Convert "(doc \"text\" declaration)" into the declaration with the
+// text as its doc comment right above it, like "(doc \"Foo does
+// things.\" (func Foo () ()))" → "// Foo does things.\nfunc Foo() {}".
+// Each line of the text gets its own "//" line.
+func nkw_doc(keywordNode *Node) string {
+	nu_require_args(keywordNode, 2, "text and a declaration")
+	text, decl := keywordNode.next, keywordNode.next.next
+	if text.content == "" || text.content[0] != '"' && text.content[0] != '`' || decl.next != nil {
+		panic("'doc' takes a string and a declaration: \"" + keywordNode.parent.String() + "\"!")
+	}
+	out := ""
+	for _, line := range strings.Split(nu_unquote(text), "\n") {
+		out += strings.TrimSpace("// "+line) + "\n"
+	}
+	return out + nc_top(decl)
+}
+
+// Convert Golid "(myVar value)", "(myVar type)" and "(myVar type
+// value)" expressions (which are to the right of var (and const)
+// expressions) into corresponding Go "myVar = value", "myVar type" and
+// "myVar type = value" expressions, telling the shapes apart by how
+// many elements they have. Two elements are a type only if the second
+// is obviously one, like "int" or "(slice T)", so "(x T)" is "x = T".
+// Several names can share a type and value, as in "(a b int 0)" → "a,
+// b int = 0". Entries without their own type get sharedType, if it
+// isn't "".
+func nkw_var_post_kw(varNameNode *Node, sharedType string) string {
 	n := varNameNode
 	out := n.content
+	if sharedType != "" {
+		sharedType = " " + sharedType
+	}
 	n = n.next
-	if n.next == nil { // "myVar value" case
-		out += " = " + nc_value(n)
-	} else { // "myVar type value" case
-		out += " " + n.content + " = " + nc_value(n.next)
+	if n == nil { // bare "myConst" case, repeating the last const expression
+		return out + sharedType
+	} else if n.next == nil && nu_is_type_name(n) { // "myVar type" case
+		return out + " " + nc_type(n)
+	} else if n.next == nil { // "myVar value" case
+		return out + sharedType + " = " + nc_value(n)
 	}
-	return out
+	// "myVar ... type value" case
+	for ; n.next.next != nil; n = n.next {
+		out += ", " + n.content
+	}
+	return out + " " + nc_type(n) + " = " + nc_value(n.next)
 }
 
-// Convert a var Node into a Go var declaration.  TODO: This is
-// currently broken. Here's how it should convert things:
+// Convert a var (or const) Node into a Go declaration. Here's how
+// it converts things:
 // (var myVar value)→"var myVar = value"
 // (var myVar type value)→"var myVar type = value"
 // (var (myVar1 value) (myVar2 type value))
@@ -50,18 +208,46 @@ func nkw_var_post_kw(varNameNode *Node) string {
 //        myVar1 = value
 //        myVar2 type = value
 // )"
+// A type right before a group of entries is shared by the entries
+// without their own type, so "(const T ((A 1) (B 2)))" → "const ( A
+// T = 1; B T = 2 )". Bare const names still repeat the last expression,
+// type and all, so they don't get the shared type.
 func nkw_var(keywordNode *Node) string {
+	nu_require_args(keywordNode, 1, "names")
 	// "var" (or "const")
 	n := keywordNode
 	out := n.content
 	n = n.next
+	// optional type shared by a group of entries
+	sharedType := ""
+	if nu_is_shared_type(n) {
+		sharedType = nc_type(n)
+		n = n.next
+	}
+	entry := func(varNameNode *Node) string {
+		if varNameNode == nil {
+			panic("Empty '" + keywordNode.content + "' entry: \"" + keywordNode.parent.String() + "\"!")
+		}
+		if keywordNode.content == "const" && varNameNode.next == nil {
+			return nkw_var_post_kw(varNameNode, "") + "\n"
+		}
+		return nkw_var_post_kw(varNameNode, sharedType) + "\n"
+	}
 	// if it's a single-var declaration
 	if n.content != "" {
-		out += " " + nkw_var_post_kw(n) + "\n"
+		out += " " + entry(n)
 	} else { // if it's a multi-var declaration
 		out += " (\n"
 		for n != nil {
-			out += nkw_var_post_kw(n.first) + "\n"
+			switch {
+			case n.first == nil: // empty "()" group
+			case n.first.content == "": // wrapped "((myVar value) ...)" entries
+				for e := n.first; e != nil; e = e.next {
+					out += entry(e.first)
+				}
+			default:
+				out += entry(n.first)
+			}
 			n = n.next
 		}
 		out += ")"
@@ -69,37 +255,204 @@ func nkw_var(keywordNode *Node) string {
 	return out
 }
 
+// Convert a type declaration like "(type Point (struct (x int) (y
+// int)))" into Go. Generic types have type parameters between the name
+// and the type, so "(type Stack (T any) (struct (items (slice T))))" →
+// "type Stack[T any] struct { items []T }".
+func nkw_type(keywordNode *Node) string {
+	nu_require_args(keywordNode, 2, "a name and a type")
+	name := keywordNode.next
+	out := "type " + name.content
+	typ := name.next
+	if typ.next != nil {
+		out += nu_type_params(typ)
+		typ = typ.next
+	}
+	return out + " " + nc_type(typ) + "\n"
+}
+
+// Convert "(assert-implements T I)" into a compile-time check that
+// type T implements interface I, like "var _ I = (*T)(nil)" for
+// pointer types or "var _ I = *new(T)" for any other type.
+func nkw_assert_implements(keywordNode *Node) string {
+	nu_require_args(keywordNode, 2, "a type and an interface")
+	t := keywordNode.next
+	iface := nc_type(t.next)
+	if t.content == "" && t.first != nil && t.first.content == "*" || strings.HasPrefix(t.content, "*") {
+		return "var _ " + iface + " = (" + nc_type(t) + ")(nil)\n"
+	}
+	return "var _ " + iface + " = *new(" + nc_type(t) + ")\n"
+}
+
 // Convert a function Node into a Go function declaration.
 func nkw_func(keywordNode *Node) string {
+	nu_require_args(keywordNode, 3, "a name, parameters, and results")
 	// "func"
 	n := keywordNode
 	out := n.content
-	// function name
+	// receiver, if it's a method
 	n = n.next
+	if n.content == "" {
+		nu_require_args(keywordNode, 4, "a receiver, name, parameters, and results")
+		out += " (" + nu_fields(n.first) + ")"
+		n = n.next
+	}
+	// function name
 	out += " " + n.content
-	// function args
-	// TODO: This part's broken for functions that take multiple args.
+	// type parameters
 	n = n.next
-	out += "(" + nu_raw_content(n.first, " ") + ")"
+	if nu_is_type_params(n) {
+		out += nu_type_params(n)
+		n = n.next
+	}
+	// function args
+	out += "(" + nu_params(n.first) + ")"
 	// function return types
-	// TODO: This part's broken for functions that name their return values in the signature.
 	n = n.next
-	out += "(" + nu_raw_content(n.first, " ") + ")"
+	out += nu_results(n.first)
 	// function body
-	out += "{\n"
-	for n = n.next; n != nil; n = n.next {
-		out += nc_action(n) + "\n"
-	}
-	// closing brace
-	out += "}\n"
+	out += " {\n" + nu_body(n.next) + "}\n"
 	return out
 }
 
-// return text representing an "if condition { stuff() ... }" block
+// Convert a Golid map literal like "(map string int ("a" 1) ("b" 2))"
+// or "(map string int (("a" 1) ("b" 2)))" into Go like
+// "map[string]int{"a": 1, "b": 2}". Keys and values are both values,
+// so "(map int int ((+ a b) (f c)))" → "map[int]int{a + b: f(c)}". A
+// lone entry whose key and value are both two-element lists looks
+// like wrapped entries, so it has to be wrapped itself, as in "(map T
+// U (((f a) (g b))))".
+func nkw_map(keywordNode *Node) string {
+	nu_require_args(keywordNode, 2, "key and value types")
+	isEntry := func(n *Node) bool {
+		return n.first != nil && n.first.next != nil && n.first.next.next == nil
+	}
+	n := keywordNode.next.next.next
+	if n != nil && n.next == nil && n.first != nil && n.first.first != nil && nu_all_lists(n.first) {
+		wrapped := true
+		for entry := n.first; entry != nil; entry = entry.next {
+			wrapped = wrapped && isEntry(entry)
+		}
+		if wrapped {
+			n = n.first
+		}
+	}
+	out := nkw_map_type(keywordNode) + "{"
+	for ; n != nil; n = n.next {
+		if !isEntry(n) {
+			panic("Map entries need a key and a value: \"" + n.String() + "\"!")
+		}
+		out += nc_value(n.first) + ": " + nc_value(n.first.next) + ", "
+	}
+	return out + "}"
+}
+
+// Convert a Golid map type like "(map string int)" into Go.
+func nkw_map_type(keywordNode *Node) string {
+	nu_require_args(keywordNode, 2, "key and value types")
+	key := keywordNode.next
+	return "map[" + nc_type(key) + "]" + nc_type(key.next)
+}
+
+// Convert a Golid channel type like "(chan int)" into Go. Directional
+// channels are written "(chan-send int)" → "chan<- int" and
+// "(chan-recv int)" → "<-chan int". A receive-only element type gets
+// parenthesized, since Go would otherwise read "chan <-chan int" as
+// "chan<- chan int".
+func nkw_chan_type(keywordNode *Node) string {
+	nu_require_args(keywordNode, 1, "an element type")
+	elem := nc_type(keywordNode.next)
+	switch keywordNode.content {
+	case "chan-send":
+		return "chan<- " + elem
+	case "chan-recv":
+		return "<-chan " + elem
+	}
+	if strings.HasPrefix(elem, "<-") {
+		elem = "(" + elem + ")"
+	}
+	return "chan " + elem
+}
+
+// Convert a Golid type union like "(| int float64)" into Go.
+func nkw_union_type(keywordNode *Node) string {
+	nu_require_args(keywordNode, 1, "a type")
+	out := ""
+	for n := keywordNode.next; n != nil; n = n.next {
+		out += nc_type(n) + " | "
+	}
+	return out[:len(out)-len(" | ")]
+}
+
+// Convert a Golid function type like "(func (int string) (error))"
+// into Go like "func(int, string) error". It may also be written with
+// "func-type", which can't be mistaken for a function literal.
+func nkw_func_type(keywordNode *Node) string {
+	nu_require_args(keywordNode, 2, "parameters and results")
+	params := keywordNode.next
+	return "func(" + nu_fields(params.first) + ")" + nu_results(params.next.first)
+}
+
+// Convert a Golid struct type like "(struct (x y int) (io.Reader))"
+// into Go like "struct { x, y int; io.Reader }". Fields can end with a
+// tag string, as in "(X int `json:"x"`)" → "X int `json:"x"`". The
+// fields may also be wrapped in a list, as in "(anon-struct ((x int)
+// (y string)))", where "anon-struct" is the same as "struct".
+func nkw_struct_type(keywordNode *Node) string {
+	fields := keywordNode.next
+	if fields == nil {
+		return "struct{}"
+	}
+	if fields.next == nil && fields.first != nil && nu_all_lists(fields.first) {
+		fields = fields.first
+	}
+	out := "struct {\n"
+	for n := fields; n != nil; n = n.next {
+		out += nu_struct_field(n) + "\n"
+	}
+	return out + "}"
+}
+
+// Convert a Golid interface type like "(interface (Ordered) (String
+// () (string)))" into Go like "interface { Ordered; String() string }".
+// Entries with only a name embed that interface, and longer entries
+// are methods.
+func nkw_interface_type(keywordNode *Node) string {
+	if keywordNode.next == nil {
+		return "interface{}"
+	}
+	out := "interface {\n"
+	for n := keywordNode.next; n != nil; n = n.next {
+		switch {
+		case n.content != "":
+			out += n.content
+		case n.first == nil:
+			panic("Empty 'interface' element: \"" + keywordNode.parent.String() + "\"!")
+		case n.first.next == nil:
+			out += nc_type(n.first)
+		case nu_is_type(n):
+			out += nc_type(n)
+		default:
+			nu_require_args(n.first, 2, "parameters and results")
+			params := n.first.next
+			out += n.first.content + "(" + nu_params(params.first) + ")" + nu_results(params.next.first)
+		}
+		out += "\n"
+	}
+	return out + "}"
+}
+
+// return text representing an "if condition { stuff() ... }" block,
+// written as cond-like clauses "(if (condition stuff ...) ... (else
+// stuff ...))"
 func nkw_if(keywordNode *Node) string {
+	nu_require_args(keywordNode, 1, "a condition")
+	if !nu_is_clauses(keywordNode.next) {
+		return nkw_if_body(keywordNode)
+	}
 	n := keywordNode
-	// "if"
-	out := n.content + " "
+	// "if" (or "cond")
+	out := "if "
 	// first case
 	n = n.next
 	out += nc_value(n.first) + " {\n"
@@ -118,72 +471,315 @@ func nkw_if(keywordNode *Node) string {
 	return out
 }
 
+// return text representing an "if condition { stuff() ... }" block,
+// written as "(cond (condition stuff ...) ... (else stuff ...))"
+func nkw_cond(keywordNode *Node) string {
+	if !nu_is_clauses(keywordNode.next) {
+		panic("Invalid 'cond' clauses: \"" + keywordNode.parent.String() + "\"!")
+	}
+	return nkw_if(keywordNode)
+}
+
+// return text representing a cond used as a value, written as "(cond
+// [type] (condition value) ... (else value))". It's only valid as a
+// value when every clause has exactly one value and the last clause
+// is an else, so that some value is always returned. The result is a
+// function literal that's called immediately, like "func() type { if
+// condition { return value }; ...; return value }()". Without an
+// explicit type, the first literal value's type is used.
+func nkw_cond_value(keywordNode *Node) string {
+	n := keywordNode.next
+	typ := ""
+	if n != nil && n.content != "" {
+		typ = nc_type(n)
+		n = n.next
+	}
+	body := ""
+	for ; n != nil; n = n.next {
+		if n.first == nil || n.first.next == nil || n.first.next.next != nil {
+			panic("Invalid 'cond' value clause: \"" + n.String() + "\"!")
+		}
+		value := n.first.next
+		if typ == "" {
+			typ = nu_literal_type(value)
+		}
+		if n.first.content == "else" {
+			if n.next != nil {
+				panic("'else' must be the last 'cond' clause: \"" + keywordNode.parent.String() + "\"!")
+			}
+			body += "return " + nc_value(value) + "\n"
+			break
+		}
+		body += "if " + nc_value(n.first) + " {\nreturn " + nc_value(value) + "\n}\n"
+	}
+	if n == nil {
+		panic("A 'cond' value needs an 'else' clause: \"" + keywordNode.parent.String() + "\"!")
+	}
+	if typ == "" {
+		panic("Could not infer the type of a 'cond' value: \"" + keywordNode.parent.String() + "\"!")
+	}
+	return "func() " + typ + " {\n" + body + "}()"
+}
+
+// return text representing a conditional value, written as "(if-expr
+// [type] condition value else-value)", since Go has no ternary
+// operator. Like a cond value, it's a function literal that's called
+// immediately, so "(if-expr int (< a b) a b)" → "func() int { if a < b
+// { return a }; return b }()". Without an explicit type, the first
+// literal value's type is used.
+func nkw_if_expr(keywordNode *Node) string {
+	nu_require_args(keywordNode, 3, "a condition and two values")
+	n := keywordNode.next
+	typ := ""
+	if n.next.next.next != nil {
+		typ = nc_type(n)
+		n = n.next
+	}
+	cond, value, elseValue := n, n.next, n.next.next
+	if elseValue.next != nil {
+		panic("'if-expr' takes an optional type, a condition, and two values: \"" + keywordNode.parent.String() + "\"!")
+	}
+	if typ == "" {
+		typ = nu_literal_type(value)
+	}
+	if typ == "" {
+		typ = nu_literal_type(elseValue)
+	}
+	if typ == "" {
+		panic("Could not infer the type of an 'if-expr' value: \"" + keywordNode.parent.String() + "\"!")
+	}
+	return "func() " + typ + " {\nif " + nc_value(cond) + " {\nreturn " + nc_value(value) + "\n}\nreturn " + nc_value(elseValue) + "\n}()"
+}
+
+// return text representing an "if condition { stuff() ... } else {
+// other() ... }" block, written as "(if condition (body ...)
+// (else-body ...))" with an optional else-body, and optionally
+// starting with a simple statement like "(if (:= x (f)) (> x 0) ...)"
+func nkw_if_body(keywordNode *Node) string {
+	nu_require_args(keywordNode, 2, "a condition and a body")
+	n := keywordNode.next
+	// "if" and optional simple statement
+	init, n := nu_init(n, 2)
+	out := keywordNode.content + " " + init
+	// condition
+	out += nc_value(n) + " {\n"
+	// body
+	n = n.next
+	out += nu_line(nc_action(n))
+	// else-body
+	if n = n.next; n != nil {
+		out += "} else {\n" + nu_line(nc_action(n))
+	}
+	// final closing
+	out += "}\n"
+	return out
+}
+
 // return text representing a "for pre-statement; condition; post-statement { stuff() ... }" block of any type
 func nkw_for(keywordNode *Node) string {
+	nu_require_args(keywordNode, 1, "a control clause")
 	// "for"
 	out := keywordNode.content + " "
 	n := keywordNode.next
+	body := n.next
 	// get header
 	switch {
 	case n.content != "": // Golid for loops must paren the control clause.
 		panic("Invalid 'for' control clause: \"" + n.String() + "\"!")
+	case n.first == nil && nu_is_condition(n.next) && n.next.next != nil && nu_is_simple_stmt(n.next.next): // "() (cond) (post)" case ('for' loop without pre)
+		out += "; " + nc_value(n.next) + "; " + nc_action(n.next.next) + " {\n"
+		body = n.next.next.next
 	case n.first == nil: // "()" case ('infinite' loop)
 		out += "{\n"
-	case n.first.content == "range": // "(range ...)" case
-		panic("nodeForCase: 'range' isn't implemented!")
+	case n.first.content == "range" || n.first.content == "range-assign": // "(range ...)" case
+		out += nkw_range(n.first) + " {\n"
+	case nu_is_simple_stmt(n): // "(pre) (cond) (post)" case ('for' loop)
+		pre, cond := nu_init(n, 2)
+		if pre == "" {
+			panic("A 'for' loop's pre statement needs a condition and a post statement after it: \"" + keywordNode.parent.String() + "\"!")
+		}
+		out += pre + nc_value(cond) + "; " + nc_action(cond.next) + " {\n"
+		body = cond.next.next
 	case n.first.content != "": // "(condition)" case ('while' loop)
 		out += nc_value(n) + "{\n"
+	case n.next == nil && !nu_is_simple_stmt(n.first): // "((body ...))" case ('infinite' loop)
+		out += "{\n"
+		body = n
 	case n.first.first != nil: // "((pre) (cond) (post))" case ('for' loop)
+		if n.first.next == nil || n.first.next.next == nil {
+			panic("A 'for' loop's control clause needs a pre statement, a condition, and a post statement: \"" + keywordNode.parent.String() + "\"!")
+		}
 		out += ns_assign(n.first.first) + "; " + nc_value(n.first.next) + "; " + nc_action(n.first.next.next) + " {\n"
 	default:
 		panic("nodeForCase: Unhandled case!")
 	}
 	// go through body
-	for n = n.next; n != nil; n = n.next {
-		out += nc_action(n) + "\n"
-	}
+	out += nu_body(body)
 	// end brace
 	out += "}\n"
 	return out
 }
 
-// return text representing a "return [values ...]" statement
-func nkw_return(keywordNode *Node) string {
-	n := keywordNode
-	// "return"
-	out := n.content
-	// args
-	n = n.next
-	for n != nil {
-		out += " " + nc_value(n) + ","
+// return text representing the "k, v := range collection" part of a
+// for loop, written as "(range k v collection)", "(range (k v)
+// collection)", or "(range collection)". Using "range-assign" instead
+// of "range" assigns to existing variables, as in "k, v = range
+// collection".
+func nkw_range(keywordNode *Node) string {
+	nu_require_args(keywordNode, 1, "a collection")
+	n := keywordNode.next
+	op := " := "
+	if keywordNode.content == "range-assign" {
+		op = " = "
+	}
+	// variables, if any
+	out := ""
+	switch {
+	case n.next == nil: // "(range collection)" case
+	case n.content == "": // "(range (k v) collection)" case
+		out = nu_raw_content(n.first, ", ") + op
 		n = n.next
+	default: // "(range k v collection)" case
+		for ; n.next != nil; n = n.next {
+			out += n.content + ", "
+		}
+		out = out[:len(out)-len(", ")] + op
 	}
-	if out != keywordNode.content {
-		out = out[:len(out)-1]
+	// "range" and collection
+	return out + "range " + nc_value(n)
+}
+
+// return text representing a "return [values ...]" statement
+func nkw_return(keywordNode *Node) string {
+	if keywordNode.next == nil {
+		return keywordNode.content
 	}
-	return out
+	return keywordNode.content + " " + nu_values(keywordNode.next)
 }
 
-// return text representing a "switch var { case value: ... case val1 val2: ... }" block
+// return text representing a "switch var { case value: ... case val1 val2: ... }" block,
+// optionally starting with a simple statement like "(switch (:= x (f)) x ...)".
+// Without a value to switch on, as in "(switch (case (< x 0) ...) ...)",
+// each case is a boolean expression. This can also be written with
+// "()" in place of the value, as in "(switch (:= ok (check)) () ...)".
 func nkw_switch(keywordNode *Node) string {
-	n := keywordNode
-	// "switch" and value expression to switch on
-	out := n.content + " " + nc_value(n.next) + " {\n"
+	// "switch" and optional simple statement
+	init, n := nu_init(keywordNode.next, 0)
+	out := keywordNode.content + " " + init
+	// value expression to switch on, if any
+	if n != nil && n.content == "" && n.first == nil {
+		n = n.next
+	} else if n != nil && (n.content != "" || n.first.content != "case" && n.first.content != "default") {
+		out += nc_value(n) + " "
+		n = n.next
+	}
+	out += "{\n"
 	// loop thru cases
-	for n = n.next.next; n != nil; n = n.next {
+	for ; n != nil; n = n.next {
+		if n.first == nil {
+			panic("Expected a 'case' or 'default' clause in 'switch': \"" + n.String() + "\"!")
+		}
+		body := n.first.next
 		// "case" statement
 		switch c := n.first.content; c {
 		case "":
 			out += "case " + nu_raw_content(n.first.first, ", ") + ":\n"
+		case "case":
+			if body == nil {
+				panic("Missing values in 'case': \"" + n.String() + "\"!")
+			}
+			var values string
+			values, body = nu_case_values(body)
+			out += "case " + values + ":\n"
 		case "default":
 			out += "default:\n"
 		default:
 			out += "case " + c + ":\n"
 		}
 		// body of case
-		for inner := n.first.next; inner != nil; inner = inner.next {
-			out += nc_action(inner) + "\n"
+		out += nu_body(body)
+	}
+	// end brace
+	out += "}\n"
+	return out
+}
+
+// return text representing a "switch v := x.(type) { case T1: ... case
+// T2, T3: ... }" block, written as "(type-switch (:= v x) (case T1 ...)
+// (case T2 T3 ...) (default ...))", or "(type-switch x ...)" without
+// binding a variable. A case's types may also be wrapped in a list, as
+// in "(case (T2 T3) ...)", since every case needs at least one type.
+func nkw_type_switch(keywordNode *Node) string {
+	nu_require_args(keywordNode, 1, "a value")
+	n := keywordNode.next
+	// "switch" and the value whose type is switched on
+	out := "switch "
+	if n.content == "" && n.first != nil && n.first.content == ":=" {
+		nu_require_args(n.first, 2, "a variable and a value")
+		out += n.first.next.content + " := " + nc_value(n.first.next.next)
+	} else {
+		out += nc_value(n)
+	}
+	out += ".(type) {\n"
+	// loop thru cases
+	for n = n.next; n != nil; n = n.next {
+		if n.first == nil {
+			panic("Expected a 'case' or 'default' clause in 'type-switch': \"" + n.String() + "\"!")
+		}
+		body := n.first.next
+		switch n.first.content {
+		case "case":
+			types := ""
+			if body != nil && body.content == "" && !nu_is_type(body) {
+				for t := body.first; t != nil; t = t.next {
+					types += nc_type(t) + ", "
+				}
+				body = body.next
+			} else {
+				for ; body != nil && (body.content != "" || nu_is_type(body)); body = body.next {
+					types += nc_type(body) + ", "
+				}
+			}
+			if types == "" {
+				panic("Missing types in 'type-switch' case: \"" + n.String() + "\"!")
+			}
+			out += "case " + types[:len(types)-len(", ")] + ":\n"
+		case "default":
+			out += "default:\n"
+		default:
+			panic("Invalid 'type-switch' clause: \"" + n.String() + "\"!")
+		}
+		// body of case
+		out += nu_body(body)
+	}
+	// end brace
+	out += "}\n"
+	return out
+}
+
+// return text representing a "select { case comm: ... default: ... }"
+// block, where each comm is a channel operation like "(<- ch)",
+// "(<- ch v)", or "(:= v (<- ch))". A send has two operands and a
+// receive has one, so "(<- ch v)" → "ch <- v" and "(<- ch)" → "<-ch".
+func nkw_select(keywordNode *Node) string {
+	// "select"
+	out := keywordNode.content + " {\n"
+	// loop thru cases
+	for n := keywordNode.next; n != nil; n = n.next {
+		if n.first == nil || n.first.content != "case" && n.first.content != "default" {
+			panic("Expected a 'case' or 'default' clause in 'select': \"" + n.String() + "\"!")
+		}
+		body := n.first.next
+		if n.first.content == "default" {
+			out += "default:\n"
+		} else {
+			if !nu_is_comm(body) {
+				panic("A 'select' case needs a channel send or receive: \"" + n.String() + "\"!")
+			}
+			out += "case " + nc_action(body) + ":\n"
+			body = body.next
 		}
+		out += nu_body(body)
 	}
 	// end brace
 	out += "}\n"
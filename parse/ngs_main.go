@@ -6,7 +6,110 @@
 
 package parse
 
-// Convert a Node into Go code.
+import (
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io"
+	"io/ioutil"
+	"log"
+	"strings"
+)
+
+// where debugging output about conversions goes, if anywhere
+var (
+	debugging = false
+	debugLog  = log.New(ioutil.Discard, "golid: ", 0)
+)
+
+// Send debugging output about each conversion step to w. Passing nil
+// or ioutil.Discard turns it back off, which is the default.
+func SetDebugOutput(w io.Writer) {
+	if w == nil {
+		w = ioutil.Discard
+	}
+	debugging = w != ioutil.Discard
+	debugLog.SetOutput(w)
+}
+
+// Convert a Node into Go code. Top-level declarations are separated
+// by blank lines, like gofmt does, and the code ends with a single
+// newline.
 func (n *Node) GoString() string {
-	return nu_process_many(n.first, nc_top)
+	var out strings.Builder
+	eachTop(n.first, func(code string) error {
+		out.WriteString(code)
+		return nil
+	})
+	return out.String()
+}
+
+// Convert each top-level Node starting from first into Go, passing the
+// Go to emit with a blank line before it if it isn't the first. Nodes
+// that convert into nothing, like an empty import, are skipped. Errors
+// from emit stop the conversion and are returned. Trees with cycles,
+// which can only be built by hand, cause a panic instead of a hang.
+func eachTop(first *Node, emit func(string) error) error {
+	nu_check_cycles(first, map[*Node]bool{})
+	sep := ""
+	for top := first; top != nil; top = top.next {
+		code := nu_process_one(top, nc_top)
+		if strings.TrimSpace(code) == "" {
+			continue
+		}
+		if err := emit(sep + code); err != nil {
+			return err
+		}
+		sep = "\n"
+	}
+	return nil
+}
+
+// Convert a Node into Go code like GoString, but write it to w one
+// top-level declaration at a time instead of building it all up in
+// memory. Errors from converting invalid Golid or from w are returned.
+func (n *Node) WriteGo(w io.Writer) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = panicError(r)
+		}
+	}()
+	return eachTop(n.first, func(code string) error {
+		_, err := io.WriteString(w, code)
+		return err
+	})
+}
+
+// Convert a Node into gofmt-formatted Go code. If the generated code
+// doesn't parse as Go, then the unformatted code is returned along
+// with the error, to help see what went wrong.
+func (n *Node) FormatGo() (string, error) {
+	code := n.GoString()
+	formatted, err := format.Source([]byte(code))
+	if err != nil {
+		return code, err
+	}
+	return string(formatted), nil
+}
+
+// Convert a Node into a go/ast syntax tree for use with Go tools like
+// go/printer and go/types. The tree is an *ast.File parsed from
+// GoString's output, so it's only returned if that's valid Go. It
+// keeps the Go's comments, and its positions are in the returned
+// FileSet, which tools need to print the comments in the right places.
+// Errors from converting invalid Golid or from parsing the Go are
+// returned.
+func (n *Node) ToGoAST() (fset *token.FileSet, file *ast.File, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			fset, file, err = nil, nil, panicError(r)
+		}
+	}()
+	fset = token.NewFileSet()
+	file, err = parser.ParseFile(fset, "", n.GoString(), parser.ParseComments)
+	if err != nil {
+		return nil, nil, err
+	}
+	return fset, file, nil
 }
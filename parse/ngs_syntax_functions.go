@@ -8,36 +8,340 @@
 
 package parse
 
-// Process an assignment, starting from the first Node.
+import (
+	"go/token"
+	"strings"
+)
+
+// Process an assignment, starting from the first Node. Several
+// targets may get several values, as in "(= (a b) b a)" → "a, b = b, a".
+// Compound assignments like "(>>= x 2)" → "x >>= 2" take one of each.
 func ns_assign(first *Node) string {
+	switch first.content {
+	case "++", "--":
+		nu_require_args(first, 1, "a target")
+	case "=", ":=":
+		nu_require_args(first, 2, "targets and values")
+	default:
+		nu_require_args(first, 2, "a target and a value")
+		target := first.next
+		if target.next.next != nil || target.content == "" && (target.first == nil || nc_value_func(target.first.content) == nil) {
+			panic("'" + first.content + "' takes one target and one value: \"" + first.parent.String() + "\"!")
+		}
+	}
 	// Go LHS and assignment operator
-	out := first.next.content + first.content
+	out := nu_targets(first.next)
+	if first.next.next == nil { // "++" and "--"
+		return out + first.content
+	}
 	// RHS
-	// TODO: Properly parse as values.
-	out += nc_value(first.next.next)
-	return out
+	return out + " " + first.content + " " + nu_values(first.next.next)
 }
 
-// Convert a function call into Go
+// Convert a function call into Go. The function may be an expression
+// itself, as in "((. mu Lock))" → "mu.Lock()". A spread argument like
+// "src..." must be the last one. Arguments that aren't plain tokens
+// can be spread with a separate "...", as in "(f (g x) ...)" →
+// "f(g(x)...)". Go keywords can't be called, so a keyword that gets
+// here is a mistake, like "(range xs)" outside of a for loop.
 func ns_funcall(first *Node) string {
-	out := first.content + "("
+	if hint, ok := nu_keyword_hints[first.content]; ok {
+		panic("Go keyword '" + first.content + "' can't be called like a function; " + hint + ": \"" + first.parent.String() + "\"!")
+	}
+	args := ""
 	for n := first.next; n != nil; n = n.next {
-		out += nc_value(n) + ", "
+		switch {
+		case n.content == "..." && n == first.next:
+			panic("'...' needs an argument before it to spread: \"" + first.parent.String() + "\"!")
+		case n.content == "..." && n.next == nil:
+			args += "..."
+		case strings.HasSuffix(n.content, "...") && (n.next != nil || n.content == "..."):
+			panic("Spread argument must be last: \"" + first.parent.String() + "\"!")
+		case n == first.next:
+			args += nc_value(n)
+		default:
+			args += ", " + nc_value(n)
+		}
 	}
-	offset := len(out) - len(", ")
-	if out[offset:] == ", " {
-		out = out[:offset]
+	return nu_operand(first) + "(" + args + ")"
+}
+
+// Convert a predeclared constant that's alone in a list, like the
+// "(nil)" in "(return (nil))", into Go like "nil". Constants can't be
+// called, so anything after one is an error.
+func ns_constant(first *Node) string {
+	if first.next != nil {
+		panic("Cannot call predeclared constant '" + first.content + "': \"" + first.parent.String() + "\"!")
 	}
-	out += ")"
-	return out
+	return first.content
 }
 
-// Convert a Lisp math function call into Go form.
+// Convert a Lisp math function call into Go form. The number of
+// operands decides what an operator means: a single operand makes a
+// unary expression, like "(* p)" → "*p" or "(& x)" → "&x", while two
+// or more make a binary one, like "(* a b)" → "a * b", with more than
+// two folded together, like "(&& a b c)" → "a && b && c". Operands are
+// only parenthesized when Go's operator precedence needs it, so "(+ a
+// (* b c))" → "a + b * c" but "(* (+ a b) c)" → "(a + b) * c".
 func ns_math(first *Node) string {
 	op := first.content
 	n := first.next
-	lhs := n
+	switch {
+	case n == nil:
+		panic("Missing operands for '" + op + "': \"" + first.parent.String() + "\"!")
+	case n.next == nil:
+		switch op {
+		case "+", "-", "!", "^", "*", "&":
+			return op + nu_operand(n)
+		}
+		panic("Operator '" + op + "' needs two operands: \"" + first.parent.String() + "\"!")
+	case op == "!":
+		panic("Operator '!' needs one operand: \"" + first.parent.String() + "\"!")
+	}
+	prec := nu_precedence(first.parent)
+	out := ""
+	for ; n != nil; n = n.next {
+		v := nc_value(n)
+		// Go's binary operators are left-associative, so only the
+		// first operand can skip parentheses at the same precedence.
+		if p := nu_precedence(n); p != 0 && (p < prec || p == prec && n != first.next) {
+			v = "(" + v + ")"
+		}
+		if n != first.next {
+			out += " " + op + " "
+		}
+		out += v
+	}
+	return out
+}
+
+// Convert a Lisp selector like "(. obj field method)" into Go form
+// like "obj.field.method". The first operand may be any value, and
+// operator expressions get parenthesized, as in "(. (& b) String)" →
+// "(&b).String".
+func ns_selector(first *Node) string {
+	nu_require_args(first, 1, "a value")
+	out := nu_operand(first.next)
+	for n := first.next.next; n != nil; n = n.next {
+		if !token.IsIdentifier(n.content) {
+			panic("Selector names must be identifiers: \"" + first.parent.String() + "\"!")
+		}
+		out += "." + n.content
+	}
+	return out
+}
+
+// Convert a Golid type assertion like "(assert x (* T))" into Go like
+// "x.(*T)". Operator expressions get parenthesized, like with
+// selectors.
+func ns_type_assert(first *Node) string {
+	nu_require_args(first, 2, "a value and a type")
+	return nu_operand(first.next) + ".(" + nc_type(first.next.next) + ")"
+}
+
+// Convert a Golid type conversion like "(convert (slice byte) s)"
+// into Go like "[]byte(s)". Types that would otherwise be read as
+// part of the value, like pointer, receive-only channel, and function
+// types, get parenthesized, as in "(*T)(x)".
+func ns_convert(first *Node) string {
+	nu_require_args(first, 2, "a type and a value")
+	if first.next.next.next != nil {
+		panic("'convert' takes one value: \"" + first.parent.String() + "\"!")
+	}
+	return nu_type_operand(first.next) + "(" + nc_value(first.next.next) + ")"
+}
+
+// Convert a Golid method expression like "(method-expr T Method)" into
+// Go like "T.Method", which is a function taking the receiver as its
+// first argument. Pointer receivers get parenthesized, as in
+// "(method-expr (* T) Method)" → "(*T).Method". A method value bound
+// to a receiver is a plain selector, like "(. obj Method)".
+func ns_method_expr(first *Node) string {
+	nu_require_args(first, 2, "a type and a method")
+	method := first.next.next
+	if !token.IsIdentifier(method.content) || method.next != nil {
+		panic("'method-expr' takes a type and a method name: \"" + first.parent.String() + "\"!")
+	}
+	return nu_type_operand(first.next) + "." + method.content
+}
+
+// Convert "(raw \"go code\")" into the Go code inside the string,
+// without any processing. This works in any context, as an escape
+// hatch for Go that Golid can't express yet. Backquoted strings work
+// too, which is handy for code containing quotes or line breaks.
+func ns_raw(first *Node) string {
+	n := first.next
+	if n == nil || n.next != nil || n.content == "" || n.content[0] != '"' && n.content[0] != '`' {
+		panic("'raw' takes a single string of Go code: \"" + first.parent.String() + "\"!")
+	}
+	return nu_unquote(n)
+}
+
+// Convert a Lisp function literal like "(lambda (x int) (int) (return
+// x))" into Go like "func(x int) int { return x }". It may also be
+// written with "func" instead of "lambda".
+func ns_lambda(first *Node) string {
+	nu_require_args(first, 2, "parameters and results")
+	params := first.next
+	results := params.next
+	return "func(" + nu_params(params.first) + ")" + nu_results(results.first) +
+		" {\n" + nu_body(results.next) + "}"
+}
+
+// Convert a Golid struct literal like "(new-struct Point (X 1) (Y
+// 2))" or "(new-struct Point ((X 1) (Y 2)))" into Go like "Point{X: 1,
+// Y: 2}". Plain values are positional, so "(new-struct Point 1 2)" and
+// "(new-struct Point (1 2))" → "Point{1, 2}". Using "&struct" instead
+// of "new-struct" makes a pointer, like "&Point{X: 1, Y: 2}".
+func ns_new_struct(first *Node) string {
+	nu_require_args(first, 1, "a type")
+	n := first.next
+	out := nc_type(n) + "{"
+	if first.content == "&struct" {
+		out = "&" + out
+	}
 	n = n.next
-	rhs := n
-	return "(" + nc_value(lhs) + " " + op + " " + nc_value(rhs) + ")"
+	if n != nil && n.next == nil && n.first != nil {
+		if unwrapped := nu_unwrap_elements(nil, n); unwrapped != n {
+			return out + nu_elements(nil, unwrapped) + "}"
+		} else if n.first.first != nil && nu_all_lists(n.first) {
+			n = n.first
+		}
+	}
+	for ; n != nil; n = n.next {
+		if n.content != "" {
+			out += nc_value(n) + ", "
+			continue
+		}
+		field := n.first
+		if field == nil || field.content == "" {
+			panic("Struct literal entries must be values or \"(field value)\" pairs: \"" + first.parent.String() + "\"!")
+		}
+		nu_require_args(field, 1, "a value")
+		if field.next.next != nil {
+			panic("Field '" + field.content + "' takes one value: \"" + first.parent.String() + "\"!")
+		}
+		out += field.content + ": " + nc_value(field.next) + ", "
+	}
+	return out + "}"
+}
+
+// Convert a Golid index expression like "(index a i)" into Go like
+// "a[i]". Multiple indices are comma-separated.
+func ns_index(first *Node) string {
+	nu_require_args(first, 2, "a value and an index")
+	return nu_operand(first.next) + "[" + nu_values(first.next.next) + "]"
+}
+
+// Convert a Golid slice expression like "(slice-expr a lo hi)" or
+// "(slice-expr a lo hi max)" into Go like "a[lo:hi]" or
+// "a[lo:hi:max]". A lone low bound means "a[lo:]", and "()" omits a
+// bound, so "(slice-expr a () hi)" → "a[:hi]".
+func ns_slice_expr(first *Node) string {
+	nu_require_args(first, 2, "a value and bounds")
+	out := nu_operand(first.next) + "["
+	bounds := 0
+	for n := first.next.next; n != nil; n = n.next {
+		if n.content != "" || n.first != nil {
+			out += nc_value(n)
+		}
+		out += ":"
+		bounds++
+	}
+	if bounds > 1 {
+		out = out[:len(out)-len(":")]
+	}
+	return out + "]"
+}
+
+// Convert a call to make or new, whose first argument is a type, into
+// Go. For example, "(make (chan int) 5)" → "make(chan int, 5)" and
+// "(make (slice int) 0 10)" → "make([]int, 0, 10)". The sizes after
+// make's type are values, and new takes only the type.
+func ns_make(first *Node) string {
+	nu_require_args(first, 1, "a type")
+	out := first.content + "(" + nc_type(first.next)
+	sizes := 0
+	for n := first.next.next; n != nil; n = n.next {
+		out += ", " + nc_value(n)
+		sizes++
+	}
+	if first.content == "new" && sizes > 0 {
+		panic("'new' takes only a type: \"" + first.parent.String() + "\"!")
+	} else if sizes > 2 {
+		panic("'make' takes a type and at most a length and capacity: \"" + first.parent.String() + "\"!")
+	}
+	return out + ")"
+}
+
+// Convert a call to panic or recover into Go, checking that panic
+// gets exactly one value and recover gets none, like "(panic err)" →
+// "panic(err)" and "(recover)" → "recover()".
+func ns_panic_recover(first *Node) string {
+	args := 0
+	for n := first.next; n != nil; n = n.next {
+		args++
+	}
+	if first.content == "panic" && args != 1 {
+		panic("'panic' takes one value: \"" + first.parent.String() + "\"!")
+	} else if first.content == "recover" && args != 0 {
+		panic("'recover' takes no arguments: \"" + first.parent.String() + "\"!")
+	}
+	return ns_funcall(first)
+}
+
+// Convert a Golid slice literal like "(slice int 1 2 3)" or "(slice
+// int (1 2 3))" into Go like "[]int{1, 2, 3}". Elements of composite
+// types can leave out their type, so "(slice (struct (x int)) (1)
+// (2))" → "[]struct{ x int }{{1}, {2}}".
+func ns_slice(first *Node) string {
+	nu_require_args(first, 1, "an element type")
+	return ns_slice_type(first) + "{" + nu_elements(first.next, nu_unwrap_elements(first.next, first.next.next)) + "}"
+}
+
+// Convert a Golid array literal like "(array 3 int 1 2 3)" or "(array
+// ... int (1 2 3))" into Go like "[3]int{1, 2, 3}".
+func ns_array(first *Node) string {
+	nu_require_args(first, 2, "a length and an element type")
+	elemType := first.next.next
+	return ns_array_type(first) + "{" + nu_elements(elemType, nu_unwrap_elements(elemType, elemType.next)) + "}"
+}
+
+// Convert a Lisp channel operation into Go. "(<- ch)" receives from
+// ch and "(<- ch v)" sends v on ch.
+func ns_chan_op(first *Node) string {
+	nu_require_args(first, 1, "a channel")
+	ch := first.next
+	if ch.next == nil {
+		return "<-" + nu_operand(ch)
+	}
+	if ch.next.next != nil {
+		panic("'<-' takes a channel and at most one value to send: \"" + first.parent.String() + "\"!")
+	}
+	return nc_value(ch) + " <- " + nc_value(ch.next)
+}
+
+// Convert a Lisp pointer type like "(* Server)" into Go form.
+func ns_pointer_type(first *Node) string {
+	nu_require_args(first, 1, "a type")
+	return "*" + nc_type(first.next)
+}
+
+// Convert a Golid type term like "(~ int)", for any type whose
+// underlying type is int, into Go form.
+func ns_underlying_type(first *Node) string {
+	nu_require_args(first, 1, "a type")
+	return "~" + nc_type(first.next)
+}
+
+// Convert a Golid array type like "(array 4 byte)" into Go form.
+func ns_array_type(first *Node) string {
+	nu_require_args(first, 2, "a length and an element type")
+	return "[" + nc_value(first.next) + "]" + nc_type(first.next.next)
+}
+
+// Convert a Golid slice type like "(slice byte)" into Go form.
+func ns_slice_type(first *Node) string {
+	nu_require_args(first, 1, "an element type")
+	return "[]" + nc_type(first.next)
 }
@@ -0,0 +1,1242 @@
+// test converting individual Golid forms into Go
+
+package parse
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/format"
+	"strings"
+	"testing"
+)
+
+// Parse a single parenthesized Golid form and convert it with the
+// given nc_* function, turning any panic into an error.
+func convertForm(in string, f func(*Node) string) (out string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	// parseRoot avoids stripping layers from forms like "((. mu Lock))"
+	root, err := parseRoot(in)
+	if err != nil {
+		return "", err
+	}
+	return f(root.first), nil
+}
+
+// Run Go code through gofmt after wrapping it with prefix and suffix
+// to make it a parseable file. All whitespace and semicolons are then
+// collapsed into single spaces so line breaks don't count as
+// differences.
+func gofmtWrapped(prefix, code, suffix string) (string, error) {
+	out, err := format.Source([]byte(prefix + code + suffix))
+	if err != nil {
+		return "", err
+	}
+	code = strings.Replace(string(out), ";", " ", -1)
+	return strings.Join(strings.Fields(code), " "), nil
+}
+
+// Check that each Golid form converts via f into the same Go as
+// wanted, ignoring formatting differences. Both the result and the
+// wanted code are wrapped with prefix and suffix before formatting.
+func testForms(t *testing.T, f func(*Node) string, prefix, suffix string, cases map[string]string) {
+	failed := 0
+	for in, want := range cases {
+		t.Logf("Test '%s' → '%s'", in, want)
+		out, err := convertForm(in, f)
+		if err != nil {
+			t.Errorf("Could not convert '%s':\n%v", in, err)
+			failed++
+			continue
+		}
+		got, err := gofmtWrapped(prefix, out, suffix)
+		if err != nil {
+			t.Errorf("Converting '%s' made invalid Go:\n%s\n%v", in, out, err)
+			failed++
+			continue
+		}
+		want, err = gofmtWrapped(prefix, want, suffix)
+		if err != nil {
+			t.Fatalf("Invalid wanted Go for '%s': %v", in, err)
+		}
+		if got != want {
+			t.Errorf("Converting '%s' got:\n%s\ninstead of:\n%s", in, got, want)
+			failed++
+		}
+	}
+	t.Logf("Failed %v/%v tests.", failed, len(cases))
+}
+
+// Check top-level forms.
+func testTop(t *testing.T, cases map[string]string) {
+	testForms(t, nc_top, "package p\n", "\n", cases)
+}
+
+// Check action forms, as found in function bodies.
+func testAction(t *testing.T, cases map[string]string) {
+	testForms(t, nc_action, "package p\nfunc _() {\n", "\n}\n", cases)
+}
+
+// Check value forms, as found on the right of assignments.
+func testValue(t *testing.T, cases map[string]string) {
+	testForms(t, nc_value, "package p\nvar _ = ", "\n", cases)
+}
+
+// Check type forms, as found in declarations.
+func testType(t *testing.T, cases map[string]string) {
+	testForms(t, nc_type, "package p\nvar _ ", "\n", cases)
+}
+
+func TestFuncDecl(t *testing.T) {
+	testTop(t, map[string]string{
+		// builder method returning a modified copy of its receiver
+		"(func ((b Builder)) WithName ((name string)) (Builder) ((= (. b Name) name) (return b)))": "func (b Builder) WithName(name string) Builder { b.Name = name; return b }",
+		// comma-ok map lookup in a method
+		`(func ((s *Store)) Get ((key string)) (string error) ((:= (v ok) (index (. s data) key)) (if (! ok) ((return "" (fmt.Errorf "key %q not found" key)))) (return v nil)))`: `func (s *Store) Get(key string) (string, error) {
+			v, ok := s.data[key]
+			if !ok {
+				return "", fmt.Errorf("key %q not found", key)
+			}
+			return v, nil
+		}`,
+		// receivers may be ignored when only satisfying an interface
+		"(func ((_ (* T))) M () () ((f)))": "func (_ *T) M() { f() }",
+		// goroutine-safe counter, with selector chains in several positions
+		"(func ((c *Counter)) Inc () () (((. (. c mu) Lock)) (defer ((. (. c mu) Unlock))) (++ (. c n))))": "func (c *Counter) Inc() { c.mu.Lock(); defer c.mu.Unlock(); c.n++ }",
+		"(func Id ((T any)) ((x T)) (T) ((return x)))":                                                     "func Id[T any](x T) T { return x }",
+		"(func Map ((T U)) ((s (slice T)) (f (func-type (T) (U)))) ((slice U)) ((:= out (make (slice U) 0 (len s))) (for (range _ v s) ((= out (append out (f v))))) (return out)))": "func Map[T, U any](s []T, f func(T) U) []U { out := make([]U, 0, len(s)); for _, v := range s { out = append(out, f(v)) }; return out }",
+		"(func Index ((T comparable)) ((s (slice T)) (v T)) (int) ((return -1)))":                                                                                                    "func Index[T comparable](s []T, v T) int { return -1 }",
+		// inline constraint with a union of underlying types
+		"(func F ((T (interface (| (~ int) (~ int64))))) ((x T)) (T) ((return (* x 2))))": "func F[T interface {\n~int | ~int64\n}](x T) T { return x * 2 }",
+		// recovering panics into a named result
+		`(func safe () ((err error)) ((defer ((lambda () () (if (:= r (recover)) (!= r nil) ((= err (fmt.Errorf "panic: %v" r))))))) (risky) (return)))`: `func safe() (err error) {
+			defer func() {
+				if r := recover(); (r != nil) {
+					err = fmt.Errorf("panic: %v", r)
+				}
+			}()
+			risky()
+			return
+		}`,
+		"(func f () ((n int) (err error)) ((return)))": "func f() (n int, err error) { return }",
+		// declared func types work as parameter types
+		"(func Register ((h Handler)) () ((= handlers (append handlers h))))": "func Register(h Handler) { handlers = append(handlers, h) }",
+		// functional options constructor
+		"(func NewServer ((opts ...Option)) ((* Server)) ((:= s (newServer)) (return s)))": "func NewServer(opts ...Option) *Server { s := newServer(); return s }",
+		// functional options applied by calling each option
+		"(func NewServer ((opts ...Option)) ((* Server)) ((:= s (& (new-struct Server))) (for (range _ opt opts) ((opt s))) (return s)))": "func NewServer(opts ...Option) *Server { s := &Server{}; for _, opt := range opts { opt(s) }; return s }",
+		// grouping into a map of slices
+		"(func group ((items (slice Item))) ((map string (slice Item))) ((:= m (make (map string (slice Item)))) (for (range _ it items) ((= (index m (. it Key)) (append (index m (. it Key)) it)))) (return m)))": `func group(items []Item) map[string][]Item {
+			m := make(map[string][]Item)
+			for _, it := range items {
+				m[it.Key] = append(m[it.Key], it)
+			}
+			return m
+		}`,
+		// wrapped errors, exercising most of the conversion at once
+		`(func read ((path string)) ((slice byte) error) ((:= (data err) (os.ReadFile path)) (if (!= err nil) ((return nil (fmt.Errorf "read %s: %w" path err)))) (return data nil)))`: `func read(path string) ([]byte, error) {
+			data, err := os.ReadFile(path)
+			if (err != nil) {
+				return nil, fmt.Errorf("read %s: %w", path, err)
+			}
+			return data, nil
+		}`,
+	})
+}
+
+func TestImport(t *testing.T) {
+	for in, want := range map[string]string{
+		`(import "os" "fmt")`: "import (\n\"fmt\"\n\"os\"\n)",
+		`(import (alias "path") ("other/path") (_ "side/effect") (. "math"))`: "import (\n. \"math\"\n\"other/path\"\nalias \"path\"\n_ \"side/effect\"\n)",
+		"(import `raw/path`)": "import (\n`raw/path`\n)",
+		// standard library first, then everything else
+		`(import "github.com/x/y" "strings" (pq "github.com/lib/pq") "bytes")`: "import (\n\"bytes\"\n\"strings\"\n\npq \"github.com/lib/pq\"\n\"github.com/x/y\"\n)",
+		"(import \"fmt\" \"os\" (\"fmt\") `os`)":                               "import (\n\"fmt\"\n\"os\"\n)",
+		`(import (f "fmt") "os" (f "fmt"))`:                                    "import (\nf \"fmt\"\n\"os\"\n)",
+	} {
+		if got, err := convertForm(in, nc_top); err != nil || got != want {
+			t.Errorf("Converting '%s' got %q and error %v instead of %q", in, got, err, want)
+		}
+	}
+	for _, in := range []string{`(import "fmt" (f "fmt"))`, `(import (_ "a") (. "a"))`, "(import fmt)", `(import (a b "c"))`} {
+		if out, err := convertForm(in, nc_top); err == nil {
+			t.Errorf("Converting '%s' gave '%s' instead of an error", in, out)
+		}
+	}
+}
+
+func TestTypeDecl(t *testing.T) {
+	testTop(t, map[string]string{
+		"(type Point (struct (x int) (y int)))":                             "type Point struct { x int; y int }",
+		"(type Buffer (struct (io.Reader) (data []string) (next *Buffer)))": "type Buffer struct { io.Reader; data []string; next *Buffer }",
+		"(type Celsius float64)":                                            "type Celsius float64",
+		"(type Handler (func ((w io.Writer)) (error)))":                     "type Handler func(w io.Writer) error",
+		"(type Set (map string (struct)))":                                  "type Set map[string]struct{}",
+		// generic types
+		"(type Stack (T any) (struct (items (slice T))))":          "type Stack[T any] struct {\nitems []T\n}",
+		"(type Pair (K V) (struct (key K) (val V)))":               "type Pair[K, V any] struct { key K; val V }",
+		"(type Set ((T comparable)) (map T (struct)))":             "type Set[T comparable] map[T]struct{}",
+		"(type Index ((K cmp.Ordered) (V any)) (map K (slice V)))": "type Index[K cmp.Ordered, V any] map[K][]V",
+	})
+}
+
+func TestAssertImplements(t *testing.T) {
+	testTop(t, map[string]string{
+		"(assert-implements (* MyHandler) http.Handler)": "var _ http.Handler = (*MyHandler)(nil)",
+		"(assert-implements *MyHandler http.Handler)":    "var _ http.Handler = (*MyHandler)(nil)",
+		"(assert-implements Celsius fmt.Stringer)":       "var _ fmt.Stringer = *new(Celsius)",
+	})
+}
+
+func TestFileHeader(t *testing.T) {
+	for in, want := range map[string]string{
+		"(generated)\n(package p)\n":                                   "// Code generated by piklisp; DO NOT EDIT.\n\npackage p\n",
+		"(generated \"my tool\")\n(package p)\n":                       "// Code generated by my tool; DO NOT EDIT.\n\npackage p\n",
+		"(go:build linux && !cgo)\n(package p)\n":                      "//go:build linux && !cgo\n\npackage p\n",
+		"(go:build \"linux && (386 || amd64)\")\n(package p)\n":        "//go:build linux && (386 || amd64)\n\npackage p\n",
+		"(generated gen)\n(go:build ignore)\n(package p)\n(var x 1)\n": "// Code generated by gen; DO NOT EDIT.\n\n//go:build ignore\n\npackage p\n\nvar x = 1\n",
+	} {
+		if got, err := ConvertString(in); err != nil || got != want {
+			t.Errorf("Converting %q got %q and error %v instead of %q", in, got, err, want)
+		}
+	}
+	for _, in := range []string{
+		"(package p)\n(go:build linux)\n",
+		"(package p)\n(generated)\n",
+		"(go:build)\n(package p)\n",
+		"(go:build linux (a b))\n(package p)\n",
+	} {
+		if out, err := ConvertString(in); err == nil {
+			t.Errorf("Converting %q gave %q instead of an error", in, out)
+		}
+	}
+}
+
+func TestDoc(t *testing.T) {
+	for in, want := range map[string]string{
+		"(package p)\n(doc \"Foo does the thing.\" (func Foo () ()))\n":                                   "package p\n\n// Foo does the thing.\nfunc Foo() {\n}\n",
+		"(package p)\n(doc \"Point is a point.\\n\\nIt has X and Y.\" (type Point (struct (X Y int))))\n": "package p\n\n// Point is a point.\n//\n// It has X and Y.\ntype Point struct {\n\tX, Y int\n}\n",
+		"(package p)\n(doc `Max is the\nlargest size.` (const Max 10))\n":                                 "package p\n\n// Max is the\n// largest size.\nconst Max = 10\n",
+		"(doc \"Package p does things.\" (package p))\n(var x 1)\n":                                       "// Package p does things.\npackage p\n\nvar x = 1\n",
+	} {
+		if got, err := ConvertString(in); err != nil || got != want {
+			t.Errorf("Converting %q got %q and error %v instead of %q", in, got, err, want)
+		}
+	}
+	for _, in := range []string{
+		"(package p)\n(doc (func Foo () ()))\n",
+		"(package p)\n(doc Foo (func Foo () ()))\n",
+		"(package p)\n(doc \"x\" (var a 1) (var b 2))\n",
+	} {
+		if out, err := ConvertString(in); err == nil {
+			t.Errorf("Converting %q gave %q instead of an error", in, out)
+		}
+	}
+}
+
+func TestParams(t *testing.T) {
+	testTop(t, map[string]string{
+		"(func f () () ((g)))":                "func f() { g() }",
+		"(func f ((a int)) () ((g a)))":       "func f(a int) { g(a) }",
+		"(func f ((a int) (b int)) () ((g)))": "func f(a, b int) { g() }",
+		"(func f ((a int) (b int) (s []*Foo) (m (map string int)) (c int)) () ((g)))": "func f(a, b int, s []*Foo, m map[string]int, c int) { g() }",
+		"(func f ((a b int) (c int) (d string)) () ((g)))":                            "func f(a, b, c int, d string) { g() }",
+	})
+}
+
+func TestVar(t *testing.T) {
+	testTop(t, map[string]string{
+		"(var x 5)":                         "var x = 5",
+		"(var x int 5)":                     "var x int = 5",
+		"(var ((x int 5)))":                 "var ( x int = 5 )",
+		"(var ((x int) (s []string)))":      "var ( x int; s []string )",
+		"(var ((p (* Point))))":             "var ( p *Point )",
+		"(var ((a b int 0)))":               "var ( a, b int = 0 )",
+		"(var (a (f)) (b (slice int) (g)))": "var ( a = f(); b []int = g() )",
+		// each entry shape, inferring types where there are none
+		"(var ((y int) (z (+ y 1)) (w float64 (* 2 z))))": "var ( y int; z = y + 1; w float64 = 2 * z )",
+		"(var version (. runtime Version))":               "var version = runtime.Version",
+		"(var handlers (map string Handler))":             "var handlers map[string]Handler",
+	})
+}
+
+func TestConst(t *testing.T) {
+	testTop(t, map[string]string{
+		"(const (A iota) (B) (C))": "const ( A = iota; B; C )",
+		// D repeats the last explicit expression, so it's also 100
+		"(const (A iota) (B) (C 100) (D))": "const ( A = iota; B; C = 100; D )",
+		// float literals are kept exactly
+		"(const (Pi 3.14159) (E 2.71828) (Avogadro 6.02214076e23) (Half .5))": "const ( Pi = 3.14159; E = 2.71828; Avogadro = 6.02214076e23; Half = .5 )",
+		// wrapped entries
+		"(const ((A int iota)) ((B)) ((C)))": "const ( A int = iota; B; C )",
+		"(const ((Pi 3.14)) ((E 2.71)))":     "const ( Pi = 3.14; E = 2.71 )",
+		"(const ((Pi 3.14) (E 2.71)))":       "const ( Pi = 3.14; E = 2.71 )",
+		"(const Epsilon float64 1e-9)":       "const Epsilon float64 = 1e-9",
+		// iota expressions, repeated by name-only entries
+		"(const ((_ iota)) ((KB (<< 1 (* 10 iota)))) ((MB)))":                   "const ( _ = iota; KB = 1 << (10 * iota); MB )",
+		"(const ((_ iota) (KB (<< 1 (* 10 iota))) (MB) (GB)))":                  "const ( _ = iota; KB = 1 << (10 * iota); MB; GB )",
+		"(const ((Read (<< 1 iota)) (Write) (Exec) (All (| Read Write Exec))))": "const ( Read = 1 << iota; Write; Exec; All = Read | Write | Exec )",
+		"(const Area (* Width Height))":                                         "const Area = Width * Height",
+		// a type shared by a group's entries
+		"(const Weekday ((Sunday iota) (Monday) (Tuesday)))":            "const ( Sunday Weekday = iota; Monday; Tuesday )",
+		"(const int32 ((MaxInt 2147483647) (MinInt -2147483648)))":      "const ( MaxInt int32 = 2147483647; MinInt int32 = -2147483648 )",
+		"(const Level ((Low 1) (High uint8 9) (Mid (+ Low 4))))":        "const ( Low Level = 1; High uint8 = 9; Mid Level = Low + 4 )",
+		"(var (slice string) ((names) (seen (make (slice string) 0))))": "var ( names []string; seen []string = make([]string, 0) )",
+		"(var (x ((f))))": "var ( x = f()() )",
+	})
+}
+
+func TestLocalVar(t *testing.T) {
+	testAction(t, map[string]string{
+		"(var x int)":              "var x int",
+		"(var ((x int 0)))":        "var ( x int = 0 )",
+		"(const ((n 5)))":          "const ( n = 5 )",
+		"(const limit 10)":         "const limit = 10",
+		"(if ok ((var s string)))": "if ok { var s string }",
+	})
+	testTop(t, map[string]string{
+		"(func f () () ((const ((n 5))) (var ((x int 0))) (= x n)))": "func f() { const ( n = 5 ); var ( x int = 0 ); x = n }",
+	})
+}
+
+func TestBlock(t *testing.T) {
+	testAction(t, map[string]string{
+		"(block)":                  "{}",
+		"(block (:= x 1) (f x))":   "{ x := 1; f(x) }",
+		"(block ((:= x 1) (f x)))": "{ x := 1; f(x) }",
+		"(block (:= x 1) (block (:= x 2) (f x)) (f x))": "{ x := 1; { x := 2; f(x) }; f(x) }",
+		"(if ok ((block (var x int) (g x))))":           "if ok { { var x int; g(x) } }",
+	})
+}
+
+func TestMethodCalls(t *testing.T) {
+	testAction(t, map[string]string{
+		"((. mu Lock))":           "mu.Lock()",
+		"(defer ((. mu Unlock)))": "defer mu.Unlock()",
+		"((. (. c mu) Lock))":     "c.mu.Lock()",
+		"(((. mu Lock)) (work))":  "mu.Lock(); work()",
+		"((. buf WriteString) s)": "buf.WriteString(s)",
+		// unary receivers need parentheses
+		"((. (& b) WriteString) p)": "(&b).WriteString(p)",
+		"((. (* p) Reset))":         "(*p).Reset()",
+	})
+}
+
+func TestBranches(t *testing.T) {
+	testAction(t, map[string]string{
+		"(break)":          "break",
+		"(continue)":       "continue",
+		"(break outer)":    "break outer",
+		"(continue outer)": "continue outer",
+		"(goto retry)":     "goto retry",
+		"((label retry) (if (! (try)) ((goto retry))))":                        "retry: if !try() { goto retry }",
+		"(switch x (case 1 (f) (fallthrough)) (case 2 (g)))":                   "switch x { case 1: f(); fallthrough; case 2: g() }",
+		"(for () ((for () ((if (done) ((break outer)) ((continue outer)))))))": "for { for { if done() { break outer } else { continue outer } } }",
+	})
+	for _, in := range []string{"(break outer inner)", "(continue (outer))", "(goto)", "(goto 1)", "(fallthrough next)", "(label (x))", "(label 1 (f))", "(label l (f) (g))"} {
+		if out, err := convertForm(in, nc_action); err == nil {
+			t.Errorf("Converting '%s' gave '%s' instead of an error", in, out)
+		}
+	}
+}
+
+func TestCallbacks(t *testing.T) {
+	testAction(t, map[string]string{
+		"(sort.Slice s (lambda ((i int) (j int)) (bool) (return (< (index s i) (index s j)))))":          "sort.Slice(s, func(i, j int) bool { return s[i] < s[j] })",
+		`(http.HandleFunc "/" (lambda ((w http.ResponseWriter) (r (* http.Request))) () ((serve w r))))`: `http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) { serve(w, r) })`,
+		// anonymous funcs, written with "func" instead of "lambda"
+		"(foo (func (x int) () (println x)))":         "foo(func(x int) { println(x) })",
+		"(:= f (func () ((int)) (return 1)))":         "f := func() int { return 1 }",
+		"(:= next (func () (int) (++ n) (return n)))": "next := func() int { n++; return n }",
+		`((func () () (println "now")))`:              `func() { println("now") }()`,
+	})
+}
+
+func TestSpread(t *testing.T) {
+	testAction(t, map[string]string{
+		"(= dst (append dst src...))": "dst = append(dst, src...)",
+		"(f args...)":                 "f(args...)",
+		"(fmt.Println args...)":       "fmt.Println(args...)",
+		"(fmt.Printf \"%d %d\" a b)":  "fmt.Printf(\"%d %d\", a, b)",
+		"(fmt.Printf format args...)": "fmt.Printf(format, args...)",
+		// a separate "..." spreads any expression
+		"(fmt.Println (index m k) ...)":         "fmt.Println(m[k]...)",
+		"(= s (append s (slice-expr t 1) ...))": "s = append(s, t[1:]...)",
+		"(f a ...)":                             "f(a...)",
+	})
+	for in, want := range map[string]string{
+		"(fmt.Println args...)":      "fmt.Println(args...)",
+		"(fmt.Println a (f b) ...)":  "fmt.Println(a, f(b)...)",
+		"(fmt.Printf \"%d %d\" a b)": "fmt.Printf(\"%d %d\", a, b)",
+	} {
+		if got, err := convertForm(in, nc_value); err != nil || got != want {
+			t.Errorf("Converting '%s' got %q and error %v instead of %q", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"(append dst src... extra)", "(f a ... b)", "(f a... ...)"} {
+		if out, err := convertForm(in, nc_value); err == nil {
+			t.Errorf("Converting '%s' with a non-final spread gave:\n%s", in, out)
+		}
+	}
+	if _, err := convertForm("(f ...)", nc_value); err == nil || !strings.Contains(err.Error(), "needs an argument before it") {
+		t.Errorf("Converting '(f ...)' gave error %v instead of one about the missing argument", err)
+	}
+}
+
+func TestMapLiteral(t *testing.T) {
+	testValue(t, map[string]string{
+		`(map string int ("a" 1) ("b" 2))`:   `map[string]int{"a": 1, "b": 2}`,
+		`(map string int (("a" 1) ("b" 2)))`: `map[string]int{"a": 1, "b": 2}`,
+		`(map string int ("a" (f x)))`:       `map[string]int{"a": f(x)}`,
+		// dispatch table of functions
+		`(map string (func () (error)) ("a" (lambda () (error) (return nil))))`: `map[string]func() error{"a": func() error { return nil }}`,
+		// keys are values too
+		`(map int string ((+ a b) "sum") ((* a b) "product"))`: `map[int]string{a + b: "sum", a * b: "product"}`,
+		`(map int int ((+ a 1) (f x)))`:                        `map[int]int{a + 1: f(x)}`,
+		`(map Point int ((new-struct Point 1 2) 3))`:           `map[Point]int{Point{1, 2}: 3}`,
+		`(map string int (((key a) (val b))))`:                 `map[string]int{key(a): val(b)}`,
+		`(map string int (((key a) (val b)) ("c" 1)))`:         `map[string]int{key(a): val(b), "c": 1}`,
+	})
+	// ranging over the same map binds the key and then the value
+	testAction(t, map[string]string{
+		`(for (range k v (map int string ((+ a b) "sum"))) ((fmt.Println k v)))`: `for k, v := range map[int]string{a + b: "sum"} { fmt.Println(k, v) }`,
+		`(for (range k m) ((delete m k)))`:                                       `for k := range m { delete(m, k) }`,
+		`(for (range (k v) m) ((= (index out v) k)))`:                            `for k, v := range m { out[v] = k }`,
+	})
+	for _, in := range []string{`(map string int "a")`, `(map string int ("a"))`, `(map string int ("a" 1 2))`} {
+		if out, err := convertForm(in, nc_value); err == nil {
+			t.Errorf("Converting '%s' gave '%s' instead of an error", in, out)
+		}
+	}
+}
+
+func TestStructLiteral(t *testing.T) {
+	testValue(t, map[string]string{
+		"(new-struct Server)":                                 "Server{}",
+		"(new-struct Point (X 1) (Y (+ a b)))":                "Point{X: 1, Y: a + b}",
+		"(new-struct Point 1 2)":                              "Point{1, 2}",
+		"(new-struct Outer (Inner (new-struct Inner (X 1))))": "Outer{Inner: Inner{X: 1}}",
+		// wrapped fields
+		"(new-struct Point ((x 1) (y 2)))":  "Point{x: 1, y: 2}",
+		"(new-struct Point (1 (+ a b)))":    "Point{1, a + b}",
+		"(&struct Point ((x 1) (y (f a))))": "&Point{x: 1, y: f(a)}",
+		"(&struct Point (X 1))":             "&Point{X: 1}",
+		"(&struct Server)":                  "&Server{}",
+		// positional values are values too
+		`(new-struct Pair "a" 'b')`: `Pair{"a", 'b'}`,
+	})
+	for _, in := range []string{"(new-struct T 1a2)", "(new-struct T (A))", "(new-struct T (A 1 2))", "(new-struct T (() 1))"} {
+		if out, err := convertForm(in, nc_value); err == nil {
+			t.Errorf("Converting '%s' gave '%s' instead of an error", in, out)
+		}
+	}
+}
+
+func TestSwitchCase(t *testing.T) {
+	testAction(t, map[string]string{
+		"(switch x (case 1 (f)) (case 2 3 (g)) (default (h)))": "switch x { case 1: f(); case 2, 3: g(); default: h() }",
+		// switching on a method's result
+		"(switch ((. obj Kind)) (case K1 (f)) (case K2 (g)))": "switch obj.Kind() { case K1: f(); case K2: g() }",
+		"(switch x (case (+ y 1) (f)))":                       "switch x { case y + 1: f() }",
+		// tagless switches as if/else-if alternatives
+		"(switch (:= x (f)) (case (< x 0) (neg)) (case (== x 0) (zero)) (default (pos)))": "switch x := f(); { case x < 0: neg(); case x == 0: zero(); default: pos() }",
+		"(switch (case (> n 9) (big)) (default (small)))":                                 "switch { case n > 9: big(); default: small() }",
+		"(switch (:= x (f)) x (case 1 (g)))":                                              "switch x := f(); x { case 1: g() }",
+		"(switch (:= ok (check)) () (case ok (g)))":                                       "switch ok := check(); { case ok: g() }",
+		"(switch (= n (len s)) (% n 2) (case 0 (even)))":                                  "switch n = len(s); n % 2 { case 0: even() }",
+		"(switch (++ i) ())":                                                              "switch i++; {}",
+		// a plain break would only leave the switch
+		"(label loop (for () (switch x (case 1 (break loop)))))":                                           "loop: for { switch x { case 1: break loop } }",
+		"(label outer (for (range _ row rows) ((for (range _ v row) ((if (< v 0) ((continue outer))))))))": "outer: for _, row := range rows { for _, v := range row { if (v < 0) { continue outer } } }",
+	})
+}
+
+func TestTypeSwitch(t *testing.T) {
+	testAction(t, map[string]string{
+		"(type-switch (:= v x) (case int (handleInt v)) (case string (handleStr v)) (default (handleDefault v)))": "switch v := x.(type) { case int: handleInt(v); case string: handleStr(v); default: handleDefault(v) }",
+		"(type-switch x (case int int64 (f)) (case (* T) error (g)) (default))":                                   "switch x.(type) { case int, int64: f(); case *T, error: g(); default: }",
+		"(type-switch (:= v ((. r Value))) (case nil (return)))":                                                  "switch v := r.Value().(type) { case nil: return }",
+		// types wrapped in a list
+		"(type-switch (:= v x) (case (int) (f v)) (case (string (* T)) (g v)) (default (h v)))": "switch v := x.(type) { case int: f(v); case string, *T: g(v); default: h(v) }",
+	})
+}
+
+func TestPointerOps(t *testing.T) {
+	testAction(t, map[string]string{
+		"(:= p (& x))":                    "p := &x",
+		"(:= v (* p))":                    "v := *p",
+		"(:= v (* a b))":                  "v := a * b",
+		"(= (* p) v)":                     "*p = v",
+		"(+= (* p) (* (* q) 2))":          "*p += *q * 2",
+		"(f (& (index a i)) (* (. s p)))": "f(&a[i], *s.p)",
+	})
+	for _, in := range []string{"(*)", "(/ x)", "(== a)", "(! a b)"} {
+		if out, err := convertForm(in, nc_value); err == nil {
+			t.Errorf("Converting '%s' gave '%s' instead of an error", in, out)
+		}
+	}
+}
+
+func TestTypeAssert(t *testing.T) {
+	testAction(t, map[string]string{
+		"(:= s (assert x string))":             "s := x.(string)",
+		"(:= (v ok) (assert x (* T)))":         "v, ok := x.(*T)",
+		"(:= r (assert (<- ch) io.Reader))":    "r := (<-ch).(io.Reader)",
+		"((. (assert x Stringer) String))":     "x.(Stringer).String()",
+		"(f (assert (index m k) (slice int)))": "f(m[k].([]int))",
+	})
+}
+
+func TestConvert(t *testing.T) {
+	for in, want := range map[string]string{
+		"(convert int x)":                     "int(x)",
+		"(convert (slice byte) s)":            "[]byte(s)",
+		"(convert MyType (+ a b))":            "MyType(a + b)",
+		"(convert (map string int) m)":        "map[string]int(m)",
+		"(convert (* T) p)":                   "(*T)(p)",
+		"(convert (chan-recv int) ch)":        "(<-chan int)(ch)",
+		"(convert (func () ()) f)":            "(func())(f)",
+		"(convert string (index (f) i))":      "string(f()[i])",
+		"(len (convert (slice rune) \"ab\"))": "len([]rune(\"ab\"))",
+	} {
+		if got, err := convertForm(in, nc_value); err != nil || got != want {
+			t.Errorf("Converting '%s' got %q and error %v instead of %q", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"(convert int)", "(convert int a b)"} {
+		if out, err := convertForm(in, nc_value); err == nil {
+			t.Errorf("Converting '%s' gave '%s' instead of an error", in, out)
+		}
+	}
+}
+
+func TestRaw(t *testing.T) {
+	testAction(t, map[string]string{
+		`(raw "x := make(chan int, 10)")`:  "x := make(chan int, 10)",
+		"(raw `fmt.Println(\"hi\")`)":      `fmt.Println("hi")`,
+		`(:= y (+ (raw "x[1:]") 1))`:       "y := x[1:] + 1",
+		`(if ok ((raw "goto done")))`:      "if ok { goto done }",
+		`(:= f (make (raw "chan<- int")))`: "f := make(chan<- int)",
+	})
+	testTop(t, map[string]string{
+		`(raw "var x, y = 1, 2")`:    "var x, y = 1, 2",
+		`(var v int (raw "1 << 3"))`: "var v int = 1 << 3",
+	})
+	testType(t, map[string]string{
+		`(raw "[2][3]int")`:      "[2][3]int",
+		`(slice (raw "[2]int"))`: "[][2]int",
+	})
+	for _, in := range []string{"(raw)", "(raw x)", `(raw "a" "b")`, `(raw "\q")`} {
+		if out, err := convertForm(in, nc_action); err == nil {
+			t.Errorf("Converting '%s' gave '%s' instead of an error", in, out)
+		}
+	}
+}
+
+func TestMethodValues(t *testing.T) {
+	testValue(t, map[string]string{
+		"(. obj Method)":                 "obj.Method",
+		"(method-expr T Method)":         "T.Method",
+		"(method-expr (* T) Method)":     "(*T).Method",
+		"(method-expr io.Reader Read)":   "io.Reader.Read",
+		"((method-expr T Method) obj 1)": "T.Method(obj, 1)",
+		"((. obj Method) 1)":             "obj.Method(1)",
+	})
+	testAction(t, map[string]string{
+		"(sort.Slice s (. obj less))":           "sort.Slice(s, obj.less)",
+		"(:= f (method-expr (* Buffer) Write))": "f := (*Buffer).Write",
+	})
+	for _, in := range []string{"(method-expr T)", "(method-expr T (M))", "(method-expr T M N)", "(. (f) (g) h)", "(. x 1)"} {
+		if out, err := convertForm(in, nc_value); err == nil {
+			t.Errorf("Converting '%s' gave '%s' instead of an error", in, out)
+		}
+	}
+}
+
+func TestStructType(t *testing.T) {
+	testType(t, map[string]string{
+		"(struct)":                       "struct{}",
+		"(struct (x y int) (io.Reader))": "struct {\nx, y int\nio.Reader\n}",
+		"(struct (next (* Node)) (vals (slice int)))": "struct {\nnext *Node\nvals []int\n}",
+		// tags
+		`(struct (X int "json:\"x\""))`:                         "struct {\nX int `json:\"x\"`\n}",
+		`(struct (a b string "json:\"s,omitempty\" db:\"s\""))`: "struct {\na, b string `json:\"s,omitempty\" db:\"s\"`\n}",
+		"(struct (Items (slice (* Item)) `json:\"items\"`))":    "struct {\nItems []*Item `json:\"items\"`\n}",
+		`(struct (io.Reader "embedded") (n int))`:               "struct {\nio.Reader `embedded`\nn int\n}",
+		`(struct (q string "a` + "`" + `b"))`:                   "struct {\nq string \"a`b\"\n}",
+		// anonymous structs with wrapped fields
+		"(anon-struct ((x int) (y string)))":             "struct {\nx int\ny string\n}",
+		"(slice (anon-struct ((name string) (n int))))":  "[]struct {\nname string\nn int\n}",
+		"(map string (anon-struct ((x y int))))":         "map[string]struct {\nx, y int\n}",
+		"(anon-struct ((* Node) `json:\"-\"`))":          "struct {\n*Node `json:\"-\"`\n}",
+		"(struct ((X int \"json:\\\"x\\\"\") (y bool)))": "struct {\nX int `json:\"x\"`\ny bool\n}",
+	})
+	testValue(t, map[string]string{
+		"(new-struct (anon-struct ((x int))) ((x 1)))":                      "struct {\nx int\n}{x: 1}",
+		"(slice (anon-struct ((name string) (n int))) (\"a\" 1) (\"b\" 2))": "[]struct {\nname string\nn int\n}{{\"a\", 1}, {\"b\", 2}}",
+	})
+	testAction(t, map[string]string{
+		"(var p (anon-struct ((X int) (Y int))))": "var p struct {\nX int\nY int\n}",
+	})
+}
+
+// Check that package-qualified names work in every type position,
+// whether written as one token like "time.Duration" or as a selector
+// like "(. time Duration)".
+func TestQualifiedTypes(t *testing.T) {
+	testType(t, map[string]string{
+		"(. time Duration)":                      "time.Duration",
+		"(* http.Request)":                       "*http.Request",
+		"(* (. http Request))":                   "*http.Request",
+		"(slice fmt.Stringer)":                   "[]fmt.Stringer",
+		"(map string (. http HandlerFunc))":      "map[string]http.HandlerFunc",
+		"(chan time.Time)":                       "chan time.Time",
+		"(func-type (io.Reader) (int error))":    "func(io.Reader) (int, error)",
+		"(struct (d time.Duration) (io.Writer))": "struct { d time.Duration; io.Writer }",
+		"(interface io.Reader (. io Closer))":    "interface { io.Reader; io.Closer }",
+	})
+	testTop(t, map[string]string{
+		"(func wait ((d time.Duration) (h (. http Handler))) (time.Time error) ((return (time.Now) nil)))": "func wait(d time.Duration, h http.Handler) (time.Time, error) { return time.Now(), nil }",
+		"(var timeout time.Duration (* 5 time.Second))":                                                    "var timeout time.Duration = 5 * time.Second",
+		"(var ((h (. http HandlerFunc) nil)))":                                                             "var ( h http.HandlerFunc = nil )",
+		"(type Handler (func-type ((. http ResponseWriter) (* http.Request)) ()))":                         "type Handler func(http.ResponseWriter, *http.Request)",
+	})
+	testValue(t, map[string]string{
+		"(convert time.Duration n)":            "time.Duration(n)",
+		"(assert v fmt.Stringer)":              "v.(fmt.Stringer)",
+		"(assert v (. fmt Stringer))":          "v.(fmt.Stringer)",
+		"(new-struct http.Client (Timeout t))": "http.Client{Timeout: t}",
+		"(make (chan (. time Time)) 1)":        "make(chan time.Time, 1)",
+	})
+}
+
+func TestChanType(t *testing.T) {
+	testType(t, map[string]string{
+		"(chan int)":                  "chan int",
+		"(chan-send int)":             "chan<- int",
+		"(chan-recv (slice byte))":    "<-chan []byte",
+		"(chan (chan-recv int))":      "chan (<-chan int)",
+		"(chan-send (chan-recv int))": "chan<- <-chan int",
+		"(chan (chan-send int))":      "chan chan<- int",
+		"(chan (anon-struct))":        "chan struct{}",
+	})
+	testAction(t, map[string]string{
+		"(var done (chan-recv (anon-struct)))": "var done <-chan struct{}",
+		"(:= ch (make (chan-send error) 1))":   "ch := make(chan<- error, 1)",
+		"(= ch (make (chan (chan-recv int))))": "ch = make(chan (<-chan int))",
+	})
+	testTop(t, map[string]string{
+		"(func worker ((in (chan-recv int)) (out (chan-send int))) ())": "func worker(in <-chan int, out chan<- int) {\n}",
+	})
+}
+
+func TestFuncType(t *testing.T) {
+	testType(t, map[string]string{
+		"(func-type (int string) (error))":          "func(int, string) error",
+		"(func-type () ())":                         "func()",
+		"(func-type ((r Request)) (Response bool))": "func(r Request) (Response, bool)",
+		"(func-type ((func-type (int) ())) ())":     "func(func(int))",
+	})
+	testTop(t, map[string]string{
+		"(var ((handler (func-type (Request) (Response)))))":                        "var (\nhandler func(Request) Response\n)",
+		"(type Server (struct (handle (func-type (string) (error)))))":              "type Server struct {\nhandle func(string) error\n}",
+		"(func Apply ((f (func-type (int) (int))) (x int)) (int) ((return (f x))))": "func Apply(f func(int) int, x int) int { return f(x) }",
+	})
+}
+
+func TestArrayType(t *testing.T) {
+	testType(t, map[string]string{
+		"(array 4 byte)":              "[4]byte",
+		"(array (* 2 n) (slice int))": "[2 * n][]int",
+	})
+}
+
+func TestInterfaceType(t *testing.T) {
+	testType(t, map[string]string{
+		"(interface)": "interface{}",
+		"(interface (Read ((p (* Buffer))) (int error)))": "interface {\nRead(p *Buffer) (int, error)\n}",
+		// constraint embedding another constraint
+		"(interface (Ordered) (String () (string)))": "interface { Ordered; String() string }",
+		"(interface io.Reader io.Writer)":            "interface { io.Reader; io.Writer }",
+	})
+}
+
+func TestMakeNew(t *testing.T) {
+	testValue(t, map[string]string{
+		"(make (slice int) 0 10)":                 "make([]int, 0, 10)",
+		"(make (slice byte) (len s))":             "make([]byte, len(s))",
+		"(make (map string int))":                 "make(map[string]int)",
+		"(make (map string (slice int)) (* n 2))": "make(map[string][]int, n*2)",
+		"(make (chan int) 5)":                     "make(chan int, 5)",
+		"(new Point)":                             "new(Point)",
+		"(new (slice (* Node)))":                  "new([]*Node)",
+		"(append s x y)":                          "append(s, x, y)",
+	})
+	for _, in := range []string{"(new Point 1)", "(make (slice int) 0 10 20)"} {
+		if _, err := convertForm(in, nc_value); err == nil {
+			t.Errorf("Converting '%s' gave no error", in)
+		}
+	}
+}
+
+func TestChannelOps(t *testing.T) {
+	testAction(t, map[string]string{
+		"(<- ch v)":                        "ch <- v",
+		"(<- ch)":                          "<-ch",
+		"(:= ch (make (chan int) 5))":      "ch := make(chan int, 5)",
+		"(go ((lambda () () (close ch))))": "go func() { close(ch) }()",
+		"(go (worker ch))":                 "go worker(ch)",
+		"(go ((func () () (close ch))))":   "go func() { close(ch) }()",
+		"(go ((func ((c (chan int))) () ((<- c 1) (close c))) ch))": "go func(c chan int) { c <- 1; close(c) }(ch)",
+		"(:= v (<- ch))":          "v := <-ch",
+		"(:= (v ok) (<- ch))":     "v, ok := <-ch",
+		"(:= (v ok) (get m k))":   "v, ok := get(m, k)",
+		"(:= (v ok) (index m k))": "v, ok := m[k]",
+	})
+	if _, err := convertForm("(<- ch 1 2)", nc_action); err == nil {
+		t.Errorf("Converting '(<- ch 1 2)' gave no error")
+	}
+}
+
+func TestIfBody(t *testing.T) {
+	testAction(t, map[string]string{
+		"(if (!= err nil) ((return err)))": "if (err != nil) { return err }",
+		"(if ok (f))":                      "if ok { f() }",
+		"(if ok ((f) (g)) ((h)))":          "if ok { f(); g() } else { h() }",
+		"(if (< a b) (f) (g))":             "if (a < b) { f() } else { g() }",
+		"(if (== (f x) (g y)) ((return)))": "if (f(x) == g(y)) { return }",
+		"(if (:= x (f)) (> x 0) ((g x)))":  "if x := f(); (x > 0) { g(x) }",
+		// other init statements
+		"(if (= x (f)) (> x 0) ((g x)))":                    "if x = f(); x > 0 { g(x) }",
+		"(if (:= (v ok) (index m k)) ok ((g v)) ((h)))":     "if v, ok := m[k]; ok { g(v) } else { h() }",
+		"(if (+= n 1) (> n limit) ((return)))":              "if n += 1; n > limit { return }",
+		"(if (++ n) (== (% n 2) 0) ((g)))":                  "if n++; n%2 == 0 { g() }",
+		"(if (<- ch v) (done) ((return)))":                  "if ch <- v; done() { return }",
+		"(if (:= err (f)) (!= err nil) ((return err)) (g))": "if err := f(); err != nil { return err } else { g() }",
+		"(if (<- ch) ((g)))":                                "if <-ch { g() }",
+		"(if (true (f)) ((g) (h)))":                         "if true { f() } else if g() { h() }",
+	})
+}
+
+func TestCond(t *testing.T) {
+	testAction(t, map[string]string{
+		"(cond ((< x 0) (neg)) ((== x 0) (zero)) (else (pos)))": "if (x < 0) { neg() } else if (x == 0) { zero() } else { pos() }",
+		// defaulting config values
+		`(:= x (cond ((!= a "") a) (else "default")))`: `x := func() string { if (a != "") { return a }; return "default" }()`,
+		"(:= n (cond int ((> a b) a) (else b)))":       "n := func() int { if (a > b) { return a }; return b }()",
+	})
+	for _, in := range []string{
+		`(cond ((!= a "") a))`,              // no else to always return a value
+		`(cond ((!= a "") a b) (else "x"))`, // more than one value
+		`(cond (else "x") ((!= a "") a))`,   // else isn't last
+		`(cond ((!= a "") a) (else b))`,     // no literal to infer the type from
+	} {
+		if out, err := convertForm(in, nc_value); err == nil {
+			t.Errorf("Converting invalid cond value '%s' gave:\n%s", in, out)
+		}
+	}
+}
+
+func TestIfExpr(t *testing.T) {
+	testAction(t, map[string]string{
+		"(:= n (if-expr int (> a b) a b))":          "n := func() int { if a > b { return a }; return b }()",
+		`(:= s (if-expr (== n 1) "" "s"))`:          `s := func() string { if n == 1 { return "" }; return "s" }()`,
+		"(:= r (if-expr ok x 0.5))":                 "r := func() float64 { if ok { return x }; return 0.5 }()",
+		"(f (if-expr (* T) (== p nil) (& d) p))":    "f(func() *T { if p == nil { return &d }; return p }())",
+		"(return (if-expr error (f) (g) nil) true)": "return func() error { if f() { return g() }; return nil }(), true",
+	})
+	// exact output, before gofmt
+	want := "x := func() int {\nif c {\nreturn 1\n}\nreturn 2\n}()"
+	if got, err := convertForm("(:= x (if-expr c 1 2))", nc_action); err != nil || got != want {
+		t.Errorf("Got %q and error %v instead of %q", got, err, want)
+	}
+	for _, in := range []string{"(if-expr c a)", "(if-expr c a b)", "(if-expr int c a b d)"} {
+		if out, err := convertForm(in, nc_value); err == nil {
+			t.Errorf("Converting invalid if-expr '%s' gave:\n%s", in, out)
+		}
+	}
+}
+
+func TestAssign(t *testing.T) {
+	testAction(t, map[string]string{
+		"(:= (i j) -1 -1)": "i, j := -1, -1",
+		// index and slice expressions as targets
+		"(= (index m k) v)":           "m[k] = v",
+		"(= (index (index g i) j) 0)": "g[i][j] = 0",
+		"(= (a b) b a)":               "a, b = b, a",
+		// a single call is a single value, even with several targets
+		"(:= (a b) (foo))":             "a, b := foo()",
+		"(= (a b) (swap (f a) (g b)))": "a, b = swap(f(a), g(b))",
+		"(:= (q r) (/ a b) (% a b))":   "q, r := a / b, a % b",
+		"(= (. p X) (f))":              "p.X = f()",
+		// the blank identifier discards values
+		"(:= (_ v) (foo))":   "_, v := foo()",
+		"(:= (v _) (foo))":   "v, _ := foo()",
+		"(= _ (sideEffect))": "_ = sideEffect()",
+		"(= (_ _) (pair))":   "_, _ = pair()",
+	})
+	// exact output, before gofmt
+	for in, want := range map[string]string{
+		"(:= x (+ a b))":     "x := a + b",
+		"(+= n 2)":           "n += 2",
+		"(++ (. c n))":       "c.n++",
+		"(++ i)":             "i++",
+		"(-- i)":             "i--",
+		"(-- (index a i))":   "a[i]--",
+		"(%= n 7)":           "n %= 7",
+		"(&= m (^ k))":       "m &= ^k",
+		"(^= h (f b))":       "h ^= f(b)",
+		"(<<= x (+ s 1))":    "x <<= s + 1",
+		"(>>= x 2)":          "x >>= 2",
+		"(-= (. p X) dx)":    "p.X -= dx",
+		"(*= (index a i) 2)": "a[i] *= 2",
+	} {
+		if got, err := convertForm(in, nc_action); err != nil || got != want {
+			t.Errorf("Converting '%s' got '%s' and error %v instead of '%s'", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"(+= (a b) 1 2)", "(>>= x 1 2)"} {
+		if _, err := convertForm(in, nc_action); err == nil {
+			t.Errorf("Converting '%s' gave no error", in)
+		}
+	}
+}
+
+// Check that comma-ok forms assign both a value and whether it was
+// there, for map indexing, type assertions and channel receives.
+func TestCommaOk(t *testing.T) {
+	testAction(t, map[string]string{
+		"(:= (v ok) (index m k))":                 "v, ok := m[k]",
+		"(:= v (index m k))":                      "v := m[k]",
+		"(= (_ ok) (index m \"a\"))":              "_, ok = m[\"a\"]",
+		"(:= (v ok) (index (. s cache) (+ k 1)))": "v, ok := s.cache[k + 1]",
+		"(if (:= (v ok) (index m k)) ok ((f v)))": "if v, ok := m[k]; ok { f(v) }",
+		"(:= (s ok) (assert x string))":           "s, ok := x.(string)",
+		"(:= (v ok) (<- ch))":                     "v, ok := <-ch",
+	})
+}
+
+func TestPanicRecover(t *testing.T) {
+	testAction(t, map[string]string{
+		"(panic err)":                    "panic(err)",
+		"(panic (fmt.Sprintf \"%d\" n))": "panic(fmt.Sprintf(\"%d\", n))",
+		"(recover)":                      "recover()",
+		"(:= r (recover))":               "r := recover()",
+		"(defer ((lambda () () ((if (:= r (recover)) (!= r nil) ((panic r)))))))": "defer func() { if r := recover(); r != nil { panic(r) } }()",
+	})
+	for _, in := range []string{"(recover x)", "(panic)", "(panic a b)"} {
+		for _, f := range []func(*Node) string{nc_action, nc_value} {
+			if out, err := convertForm(in, f); err == nil {
+				t.Errorf("Converting '%s' gave '%s' instead of an error", in, out)
+			}
+		}
+	}
+}
+
+// Check that value lists are comma-separated without any stray line
+// breaks, which only go between statements.
+func TestValueLists(t *testing.T) {
+	for in, want := range map[string]string{
+		"(return)":                    "return",
+		"(return a (f b) (+ c 1))":    "return a, f(b), c + 1",
+		"(return (foo x) err)":        "return foo(x), err",
+		"(return a b c)":              "return a, b, c",
+		"(f (g x) (h (k y) z))":       "f(g(x), h(k(y), z))",
+		"(= (a b) (index m k) (- n))": "a, b = m[k], -n",
+		"(if (f (g x) y) ((h)))":      "if f(g(x), y) {\nh()\n}\n",
+	} {
+		if got, err := convertForm(in, nc_action); err != nil || got != want {
+			t.Errorf("Converting '%s' got %q and error %v instead of %q", in, got, err, want)
+		}
+	}
+}
+
+func TestPredeclaredConstants(t *testing.T) {
+	testAction(t, map[string]string{
+		"(return nil)":                                 "return nil",
+		"(return (nil) false)":                         "return nil, false",
+		"(if (== x nil) ((f)))":                        "if x == nil { f() }",
+		"(:= ok (!= err nil))":                         "ok := err != nil",
+		"(= (done err) true nil)":                      "done, err = true, nil",
+		"(:= flags (slice (slice bool) (true false)))": "flags := [][]bool{{true, false}}",
+	})
+	testTop(t, map[string]string{
+		"(const ((A (<< 1 iota)) (B) (C)))": "const ( A = 1 << iota; B; C )",
+	})
+	if out, err := convertForm("(nil x)", nc_value); err == nil {
+		t.Errorf("Converting '(nil x)' gave '%s' instead of an error", out)
+	}
+}
+
+// Check that forms missing required parts give clear errors instead
+// of nil pointer dereferences.
+func TestMissingArgs(t *testing.T) {
+	cases := map[string]func(*Node) string{
+		"(func foo)":                nc_top,
+		"(func ((s *S)) M ())":      nc_top,
+		"(package)":                 nc_top,
+		"(type Point)":              nc_top,
+		"(assert-implements (* T))": nc_top,
+		"(defer)":                   nc_action,
+		"(label)":                   nc_action,
+		"(lambda ())":               nc_value,
+		"(var)":                     nc_top,
+		"(map int)":                 nc_type,
+		"(chan)":                    nc_type,
+		"(slice)":                   nc_type,
+		"(*)":                       nc_type,
+		"(func-type ())":            nc_type,
+		"(if)":                      nc_action,
+		"(if x)":                    nc_action,
+		"(for)":                     nc_action,
+		"(for (range))":             nc_action,
+		"(type-switch)":             nc_action,
+		"(:= x)":                    nc_action,
+		"(++)":                      nc_action,
+		"(new-struct)":              nc_value,
+		"(index a)":                 nc_value,
+		"(slice-expr a)":            nc_value,
+		"(assert x)":                nc_value,
+		"(make)":                    nc_value,
+		"(array 3)":                 nc_value,
+		"(<-)":                      nc_value,
+		"(.)":                       nc_value,
+	}
+	for in, f := range cases {
+		_, err := convertForm(in, f)
+		if err == nil || !strings.Contains(err.Error(), "requires") {
+			t.Errorf("Converting '%s' gave error %v instead of a missing argument error", in, err)
+		}
+	}
+	want := `'func' requires a name, parameters, and results; got 1 element: "(func foo)"!`
+	if _, err := convertForm("(func foo)", nc_top); err == nil || err.Error() != want {
+		t.Errorf("Got error %v instead of %s", err, want)
+	}
+}
+
+// Check that Go keywords used where they don't belong give errors
+// naming the keyword instead of becoming function calls.
+func TestKeywordMisuse(t *testing.T) {
+	cases := map[string]func(*Node) string{
+		"(range xs)":        nc_action,
+		"(chan int)":        nc_action,
+		"(case 1)":          nc_action,
+		"(map string int)":  nc_action,
+		"(f (return x))":    nc_action,
+		"(:= x (if a b c))": nc_action,
+		"(struct)":          nc_value,
+		"(package main)":    nc_value,
+	}
+	for in, f := range cases {
+		_, err := convertForm(in, f)
+		if err == nil || !strings.Contains(err.Error(), "Go keyword") {
+			t.Errorf("Converting '%s' gave error %v instead of a keyword error", in, err)
+		}
+	}
+	want := `Go keyword 'range' can't be called like a function; use it as a loop clause, like (for (range k v xs) ...): "(range xs)"!`
+	if _, err := convertForm("(range xs)", nc_action); err == nil || err.Error() != want {
+		t.Errorf("Got error %v instead of %s", err, want)
+	}
+}
+
+// Check that empty bodies, groups and argument lists give valid Go,
+// while empty lists where a value belongs give errors.
+func TestEmpty(t *testing.T) {
+	testTop(t, map[string]string{
+		"(func f () ())":    "func f() {\n}",
+		"(func f () () ())": "func f() {\n}",
+		"(import)":          "",
+		"(var ())":          "var ()",
+		"(const ())":        "const ()",
+		"(type T (struct))": "type T struct{}",
+	})
+	testAction(t, map[string]string{
+		"(foo)":                 "foo()",
+		"((. mu Lock))":         "mu.Lock()",
+		"(return)":              "return",
+		"(if x ())":             "if x {}",
+		"(for () ())":           "for {}",
+		"(switch)":              "switch {}",
+		"(switch x)":            "switch x {}",
+		"(select)":              "select {}",
+		"(block)":               "{}",
+		"(:= f (lambda () ()))": "f := func() {\n}",
+	})
+	for in, f := range map[string]func(*Node) string{
+		"()":         nc_top,
+		"(f ())":     nc_action,
+		"(var x ())": nc_top,
+	} {
+		if out, err := convertForm(in, f); err == nil {
+			t.Errorf("Converting '%s' gave '%s' instead of an error", in, out)
+		}
+	}
+}
+
+// Check that control structures nest in each other's bodies.
+func TestNesting(t *testing.T) {
+	testAction(t, map[string]string{
+		"(switch x (case 1 (for (range _ v vs) ((if (> v 0) ((f v)) ((g v)))))) (default (h)))":                 "switch x { case 1: for _, v := range vs { if v > 0 { f(v) } else { g(v) } }; default: h() }",
+		"(for () ((select (case (:= v (<- ch)) (switch v (case 0 (if (done) ((return)))))) (default (wait)))))": "for { select { case v := <-ch: switch v { case 0: if done() { return } }; default: wait() } }",
+	})
+	// and that they come out indented right after gofmt
+	in := `(package main)
+(func main () ()
+  (for () ((switch (f) (case 1 (if (g) ((h)) ((break))))))))`
+	want := "package main\n\nfunc main() {\n\tfor {\n\t\tswitch f() {\n\t\tcase 1:\n\t\t\tif g() {\n\t\t\t\th()\n\t\t\t} else {\n\t\t\t\tbreak\n\t\t\t}\n\t\t}\n\t}\n}\n"
+	if got, err := ConvertString(in); err != nil || got != want {
+		t.Errorf("Got:\n%s\nand error %v instead of:\n%s", got, err, want)
+	}
+}
+
+func TestBitFlags(t *testing.T) {
+	testAction(t, map[string]string{
+		"(= flags (| flags FlagA))":           "flags = flags | FlagA",
+		"(= flags (&^ flags FlagB))":          "flags = flags &^ FlagB",
+		"(if (!= (& flags FlagA) 0) ((f)))":   "if flags&FlagA != 0 { f() }",
+		"(|= flags FlagA)":                    "flags |= FlagA",
+		"(&^= flags FlagB)":                   "flags &^= FlagB",
+		"(:= mask (^ (<< 1 (>> n 2))))":       "mask := ^(1 << (n >> 2))",
+		"(:= odd (% n 2))":                    "odd := n % 2",
+		"(= flags (^ flags (| FlagA FlagB)))": "flags = flags ^ (FlagA | FlagB)",
+		// variadic folding, and unary "^" told apart from xor by arity
+		"(:= m (& a b c))":                "m := a & b & c",
+		"(:= m (| a (<< b 8) (<< c 16)))": "m := a | b<<8 | c<<16",
+		"(:= n (^ x))":                    "n := ^x",
+		"(:= n (^ x y))":                  "n := x ^ y",
+		"(:= r (+ (<< a 2) (% b 3)))":     "r := a<<2 + b%3",
+	})
+}
+
+func TestIndex(t *testing.T) {
+	testValue(t, map[string]string{
+		"(index a i)":                 "a[i]",
+		"(index (index m k) (+ i 1))": "m[k][i+1]",
+		// strings index into bytes
+		"(index s i)":       "s[i]",
+		`(index "abc" 0)`:   `"abc"[0]`,
+		"((index fns 0) x)": "fns[0](x)",
+	})
+}
+
+func TestSliceExpr(t *testing.T) {
+	testValue(t, map[string]string{
+		"(slice-expr s 1)":         "s[1:]",
+		"(slice-expr s 1 3)":       "s[1:3]",
+		"(slice-expr s () 3)":      "s[:3]",
+		"(slice-expr s (- n 1) n)": "s[n-1 : n]",
+		// full slice expressions limit capacity
+		"(slice-expr s 1 3 5)":  "s[1:3:5]",
+		"(slice-expr s () 3 5)": "s[:3:5]",
+	})
+}
+
+func TestSelect(t *testing.T) {
+	testAction(t, map[string]string{
+		"(select (case (<- ch) (handle)) (default (wait)))": "select { case <-ch: handle(); default: wait() }",
+		// sending, and receiving with comma-ok
+		"(select (case (<- ch v) ((sent))) (case (:= (v ok) (<- in)) ((got v ok))) (case (<- done) ((return))) (default ((wait))))": "select { case ch <- v: sent(); case v, ok := <-in: got(v, ok); case <-done: return; default: wait() }",
+		// receiving from a call's result for timeouts
+		"(select (case (<- ch) (handle)) (case (<- (time.After timeout)) (return ErrTimeout)))": "select { case <-ch: handle(); case <-time.After(timeout): return ErrTimeout }",
+		// one send, one receive into a variable, and a default
+		"(select (case (<- out (* x 2)) ((++ sent))) (case (= x (<- in)) ((use x))) (default ((idle))))": "select { case out <- x * 2: sent++; case x = <-in: use(x); default: idle() }",
+	})
+	for _, in := range []string{"(select (case (f x) (g)))", "(select (case))", "(select (case (:= v (f))))", "(select (<- ch))", "(select x)"} {
+		if _, err := convertForm(in, nc_action); err == nil {
+			t.Errorf("Converting '%s' gave no error", in)
+		}
+	}
+}
+
+func TestFor(t *testing.T) {
+	testAction(t, map[string]string{
+		"(for () (f))":                                                         "for { f() }",
+		"(for (< i n) (f) (++ i))":                                             "for (i < n) { f(); i++ }",
+		"(for ((:= i 0) (< i n) (++ i)) (f i))":                                "for i := 0; (i < n); i++ { f(i) }",
+		"(for (:= i 0) (< i n) (++ i) (f i))":                                  "for i := 0; (i < n); i++ { f(i) }",
+		"(for (= i 0) (< i n) (++ i) (f i))":                                   "for i = 0; i < n; i++ { f(i) }",
+		"(for ((= (i j) 0 n) (< i j) (= (i j) (+ i 1) (- j 1))) ((swap i j)))": "for i, j = 0, n; i < j; i, j = i+1, j-1 { swap(i, j) }",
+		"(for (:= (i j) 0 n) (< i j) (= (i j) (+ i 1) (- j 1)) ((swap i j)))":  "for i, j := 0, n; i < j; i, j = i+1, j-1 { swap(i, j) }",
+		"(for (:= x (f)) (valid x) (= x (next x)) ((use x)))":                  "for x := f(); valid(x); x = next(x) { use(x) }",
+		// compound post statement for stride loops
+		"(for (:= i 0) (< i n) (+= i 2) ((f i)))": "for i := 0; (i < n); i += 2 { f(i) }",
+		"(for (range i n) ((<- ch i)))":           "for i := range n { ch <- i }",
+		"(for (range (k v) m) (f k v))":           "for k, v := range m { f(k, v) }",
+		"(for (range _ v (items)) (f v))":         "for _, v := range items() { f(v) }",
+		"(for (range ch) (f))":                    "for range ch { f() }",
+		"(for (range (i) s) (f i))":               "for i := range s { f(i) }",
+		"(for (range (k v) (keys m)) (f k v))":    "for k, v := range keys(m) { f(k, v) }",
+		"(for (range-assign (k v) m) (f k v))":    "for k, v = range m { f(k, v) }",
+		"(for (range-assign k m) (f k))":          "for k = range m { f(k) }",
+		// ranging over integers and iterator functions
+		"(for (range (i) 10) ((f i)))":               "for i := range 10 { f(i) }",
+		"(for (range i (* n 2)) ((f i)))":            "for i := range n * 2 { f(i) }",
+		"(for (range 3) ((f)))":                      "for range 3 { f() }",
+		"(for (range v (. s All)) ((f v)))":          "for v := range s.All { f(v) }",
+		"(for (range (k v) (maps.All m)) ((f k v)))": "for k, v := range maps.All(m) { f(k, v) }",
+		"(for (range x (lambda ((yield (func-type (int) (bool)))) () ((yield 1)))) ((f x)))": "for x := range func(yield func(int) bool) { yield(1) } { f(x) }",
+		// no pre-statement
+		"(for () (< i n) (++ i) (f i))": "for ; (i < n); i++ { f(i) }",
+		"(for ((f) (g)))":               "for { f(); g() }",
+		// modifying a slice in place
+		"(for (range i s) ((= (index s i) (transform (index s i)))))": "for i := range s { s[i] = transform(s[i]) }",
+	})
+}
+
+func TestSliceLiteral(t *testing.T) {
+	testValue(t, map[string]string{
+		"(slice int)":                             "[]int{}",
+		"(slice int 1 (+ a b) (f x))":             "[]int{1, a + b, f(x)}",
+		"(slice (slice string) (slice string x))": "[][]string{[]string{x}}",
+		// table-driven tests
+		`(slice (struct (name string) (in int) (want int)) ("double" 2 4) ("triple" 3 9))`: `[]struct {
+			name string
+			in   int
+			want int
+		}{{"double", 2, 4}, {"triple", 3, 9}}`,
+		"(slice (slice int) (1 2) ((f x)))": "[][]int{{1, 2}, {f(x)}}",
+		// wrapped elements
+		"(slice int (1 2 3))":                 "[]int{1, 2, 3}",
+		`(slice string ("a" (f x)))`:          `[]string{"a", f(x)}`,
+		"(slice int (f x))":                   "[]int{f(x)}",
+		"(array 3 int (1 2 3))":               "[3]int{1, 2, 3}",
+		`(array ... string "a" "b")`:          `[...]string{"a", "b"}`,
+		"(array 2 (array 2 int) (1 0) (0 1))": "[2][2]int{{1, 0}, {0, 1}}",
+		"(slice (slice int) (1 2))":           "[][]int{{1, 2}}",
+		// pointers to struct literals
+		"(slice (* Point) (& (new-struct Point (X 1))))": "[]*Point{&Point{X: 1}}",
+	})
+}
+
+func TestFormatGo(t *testing.T) {
+	root, err := parseRoot(`(package main) (func main () () (:= x (+ 1 2)) (println x))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "package main\n\nfunc main() {\n\tx := 1 + 2\n\tprintln(x)\n}\n"
+	if got, err := root.FormatGo(); err != nil || got != want {
+		t.Errorf("Got %q and error %v instead of %q", got, err, want)
+	}
+	// invalid Go comes back unformatted, with an error
+	root, err = parseRoot(`(package main) (func main () () (raw "x :="))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := root.FormatGo()
+	if err == nil {
+		t.Errorf("Formatting invalid Go gave no error, with result:\n%s", got)
+	} else if got != root.GoString() {
+		t.Errorf("Got %q instead of the unformatted %q", got, root.GoString())
+	}
+}
+
+// Check that top-level declarations are separated by blank lines, with
+// empty ones skipped.
+func TestGoStringLayout(t *testing.T) {
+	root, err := parseRoot("(package main)\n(import)\n; say hi\n(func main () () (println 1))\n(var x 1)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "package main\n\n// say hi\nfunc main() {\nprintln(1)\n}\n\nvar x = 1\n"
+	if got := root.GoString(); got != want {
+		t.Errorf("Got %q instead of %q", got, want)
+	}
+	if got := Root().GoString(); got != "" {
+		t.Errorf("Got %q for an empty tree", got)
+	}
+}
+
+// A Writer that fails after accepting limit bytes
+type failingWriter struct{ limit int }
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > w.limit {
+		return w.limit, errors.New("writer is full")
+	}
+	w.limit -= len(p)
+	return len(p), nil
+}
+
+func TestWriteGo(t *testing.T) {
+	root, err := parseRoot(`(package main) (func main () () (println 1)) (func f () () (g))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := root.WriteGo(&buf); err != nil || buf.String() != root.GoString() {
+		t.Errorf("Wrote %q and got error %v instead of %q", buf.String(), err, root.GoString())
+	}
+	if err := root.WriteGo(&failingWriter{20}); err == nil || err.Error() != "writer is full" {
+		t.Errorf("Got error %v instead of the writer's error", err)
+	}
+	// invalid Golid gives an error after writing what came before it
+	root, err = parseRoot(`(package main) (bogus)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	var unknown *UnknownNodeError
+	if err := root.WriteGo(&buf); !errors.As(err, &unknown) || buf.String() != "package main\n" {
+		t.Errorf("Wrote %q and got error %v instead of an UnknownNodeError", buf.String(), err)
+	}
+}
+
+func TestSetDebugOutput(t *testing.T) {
+	root, err := parseRoot(`(package main) (func main () () (println 1))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	SetDebugOutput(&buf)
+	root.GoString()
+	SetDebugOutput(nil)
+	if !strings.Contains(buf.String(), "println(1)") {
+		t.Errorf("Debugging output is missing the converted call:\n%s", buf.String())
+	}
+	// debugging is off again
+	buf.Reset()
+	root.GoString()
+	if buf.Len() != 0 {
+		t.Errorf("Got debugging output after turning it off:\n%s", buf.String())
+	}
+}
+
+// Check that comments are kept before forms in a body, and that ones
+// with no form after them to go before are errors instead of being
+// dropped or moved onto the next declaration.
+func TestCommentPlacement(t *testing.T) {
+	in := "(func f () ()\n\t; greet\n\t(println \"hi\"))"
+	want := "func f() {\n// greet\nprintln(\"hi\")\n}\n"
+	if got, err := convertForm(in, nc_top); err != nil || got != want {
+		t.Errorf("Converting %q gave %q and error %v instead of %q", in, got, err, want)
+	}
+	for in, want := range map[string]string{
+		"(func f () ()\n\t(println \"hi\"\n\t\t; who\n\t\tname))":        `line 3, column 3: Comments can only go before a form, not before "name" in the middle of a list.`,
+		"(func f () ()\n\t(println \"hi\")\n\t; done\n)\n(func g () ())": "line 3, column 2: Comments can only go before a form, not at the end of a list.",
+		"func f () ()\n\tprintln \"hi\"\n\t; done\nfunc g () ()\n":       "line 3, column 2: Comments can only go before a form, not at the end of an indented block.",
+	} {
+		if _, err := convertForm(in, nc_top); err == nil || err.Error() != want {
+			t.Errorf("Converting %q gave error %v instead of %q", in, err, want)
+		}
+	}
+}
+
+// Convert a synthetic program of about 10,000 nodes, with one long
+// function body and one long call, to check that conversion scales
+// linearly with the amount of code.
+func BenchmarkGoString(b *testing.B) {
+	stmts := strings.Repeat("(f x y)", 2500)
+	args := strings.Repeat(" x", 2500)
+	root, err := parseRoot("(package main) (func main () () " + stmts + " (g" + args + "))")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		root.GoString()
+	}
+}
@@ -6,33 +6,118 @@ package parse
 
 import (
 	"fmt"
+	"go/scanner"
+	"go/token"
 	"runtime/debug"
+	"strconv"
+	"strings"
 )
 
 // Apply the correct nc_* function to each Node starting from first
-// and going until the end of the current level. WARNING: You will
+// and going until the end of the current level, ending each Node's Go
+// with a newline and putting its comments before it as "//" line
+// comments. This is only for declarations and statements, with
+// nu_values being the equivalent for values. WARNING: You will
 // probably get bad results if you try using this with functions other
 // than the nc_* ("node context") functions found in ngs_context.go.
 func nu_process_many(first *Node, f func(*Node) string) string {
-	out := ""
+	var out strings.Builder
 	for n := first; n != nil; n = n.next {
-		result, err := func() (out string, err error) {
-			defer func() {
-				if r := recover(); r != nil {
-					stack := string(debug.Stack())
+		out.WriteString(nu_process_one(n, f))
+	}
+	return out.String()
+}
+
+// Apply an nc_* function to a single Node like nu_process_many does,
+// panicking with an error that says which Node failed if it panics.
+// Only that error path and debugging output use fmt, so converting
+// valid Golid doesn't format anything.
+func nu_process_one(n *Node, f func(*Node) string) string {
+	result, err := func() (out string, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				stack := string(debug.Stack())
+				if rErr, ok := r.(error); ok {
+					err = fmt.Errorf("Recovered panic: %w.\n\nHere's the stack:\n%s", rErr, stack)
+				} else {
 					err = fmt.Errorf("Recovered panic: %v.\n\nHere's the stack:\n%s", r, stack)
 				}
-			}()
-			out = f(n)
-			return
+			}
 		}()
-		if err != nil {
-			panic(fmt.Errorf("Could not process code:\n%v\n\nGot error:\n%v", n, err))
-		} else {
-			out += result + "\n"
+		out = f(n)
+		return
+	}()
+	if err != nil {
+		panic(fmt.Errorf("%sCould not process code:\n%v\n\nGot error:\n%w", n.positionPrefix(), n, err))
+	}
+	if debugging { // only format the message if it goes somewhere
+		debugLog.Printf("converted %v into:\n%s", n, result)
+	}
+	if len(n.comments) == 0 {
+		return nu_line(result)
+	}
+	var out strings.Builder
+	for _, comment := range n.comments {
+		out.WriteString(strings.TrimSpace("// " + comment))
+		out.WriteByte('\n')
+	}
+	out.WriteString(nu_line(result))
+	return out.String()
+}
+
+// Panic unless at least min Nodes follow keywordNode, saying what they
+// should be, so that a malformed form like "(func foo)" gives a clear
+// error instead of a nil pointer dereference.
+func nu_require_args(keywordNode *Node, min int, what string) {
+	count := 0
+	for n := keywordNode.next; n != nil; n = n.next {
+		count++
+	}
+	if count < min {
+		plural := "s"
+		if count == 1 {
+			plural = ""
 		}
+		panic(fmt.Sprintf("'%s' requires %s; got %d element%s: \"%v\"!", keywordNode.content, what, count, plural, keywordNode.parent))
 	}
-	return out
+}
+
+// Panic if a top-level form comes after the package declaration, for
+// forms like build constraints that Go only reads before it.
+func nu_require_before_package(keywordNode *Node) {
+	top := keywordNode.parent
+	if top.parent == nil {
+		return
+	}
+	for n := top.parent.first; n != top; n = n.next {
+		if n.first != nil && n.first.content == "package" {
+			panic("'" + keywordNode.content + "' must come before the package declaration: \"" + top.String() + "\"!")
+		}
+	}
+}
+
+// Convert each value Node from first to the end of the current level
+// into Go, separated by commas as in argument lists. Unlike
+// nu_process_many, which is for declarations and statements, this
+// doesn't put anything after the last value.
+func nu_values(first *Node) string {
+	var out strings.Builder
+	for n := first; n != nil; n = n.next {
+		if n != first {
+			out.WriteString(", ")
+		}
+		out.WriteString(nc_value(n))
+	}
+	return out.String()
+}
+
+// End some Go with a line break, unless it already has one, like
+// statements that end with a block do.
+func nu_line(code string) string {
+	if strings.HasSuffix(code, "\n") {
+		return code
+	}
+	return code + "\n"
 }
 
 // Generate a list of raw Node contents (only node.content, ignoring
@@ -56,3 +141,632 @@ func nu_raw_content(first *Node, sep string) string {
 func nu_raw_content_space(first *Node) string {
 	return nu_raw_content(first, " ")
 }
+
+// Check if every Node from first to the end of the current level is a
+// plain token, without any children.
+func nu_all_atoms(first *Node) bool {
+	for n := first; n != nil; n = n.next {
+		if n.content == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// Check if a Node is a type expression, as opposed to a plain token or
+// some other list. Selectors like "(. time Duration)" count, since
+// they're qualified type names where a type is expected.
+func nu_is_type(n *Node) bool {
+	if n.first == nil {
+		return false
+	}
+	switch n.first.content {
+	case "*", "~", "|", ".", "slice", "array", "map", "chan", "chan-send", "chan-recv", "func", "func-type", "struct", "anon-struct", "interface":
+		return true
+	}
+	return false
+}
+
+// Convert a type Node into Go that can be followed by "(...)" or
+// ".Name", parenthesizing types that would otherwise be read as part
+// of what follows them, like pointer, receive-only channel, and
+// function types.
+func nu_type_operand(n *Node) string {
+	typ := nc_type(n)
+	if strings.HasPrefix(typ, "*") || strings.HasPrefix(typ, "<-") || strings.HasPrefix(typ, "func") {
+		return "(" + typ + ")"
+	}
+	return typ
+}
+
+// Go keywords that end up as function calls when they're used outside
+// of the forms they belong in, with hints about where they do belong.
+var nu_keyword_hints = map[string]string{
+	"package":     "use it at the top of a file",
+	"import":      "use it at the top level",
+	"type":        "use it at the top level, or (assert x T) for a type assertion",
+	"case":        "use it in a switch, type-switch or select",
+	"default":     "use it in a switch, type-switch or select",
+	"else":        "use it as the last clause of an if or cond",
+	"range":       "use it as a loop clause, like (for (range k v xs) ...)",
+	"chan":        "use it as a type, like (make (chan int))",
+	"struct":      "use it as a type, or (new-struct T ...) for a value",
+	"interface":   "use it as a type",
+	"func":        "use it at the top level, or (lambda ...) for a function literal",
+	"map":         "use it as a value or type, like (:= m (map string int))",
+	"if":          "it's a statement, so it can't be a value; use cond for values",
+	"for":         "it's a statement, so it can't be a value",
+	"switch":      "it's a statement, so it can't be a value",
+	"select":      "it's a statement, so it can't be a value",
+	"return":      "it's a statement, so it can't be a value",
+	"break":       "it's a statement, so it can't be a value",
+	"continue":    "it's a statement, so it can't be a value",
+	"goto":        "it's a statement, so it can't be a value",
+	"fallthrough": "it's a statement, so it can't be a value",
+	"defer":       "it's a statement, so it can't be a value",
+	"go":          "it's a statement, so it can't be a value",
+	"var":         "it's a statement, so it can't be a value",
+	"const":       "it's a statement, so it can't be a value",
+}
+
+// Check if a Node is a type shared by the var or const entries after
+// it, like the "T" in "(const T ((A 1) (B 2)))". That takes a type
+// followed by only a group of entries, each starting with a name. A
+// single var whose value calls a call's result, like "(var x ((f)))",
+// looks the same, so it needs to be in a group, like "(var (x ((f))))".
+func nu_is_shared_type(n *Node) bool {
+	group := n.next
+	if group == nil || group.next != nil || group.content != "" || group.first == nil || n.content == "" && !nu_is_type(n) {
+		return false
+	}
+	for e := group.first; e != nil; e = e.next {
+		if e.content != "" || e.first == nil || !token.IsIdentifier(e.first.content) {
+			return false
+		}
+	}
+	return true
+}
+
+// Panic if a Node list starting from first, or any list under it, links
+// back to a Node that's already been seen, since converting it would
+// never finish. Parsed trees can't have cycles, but trees built with
+// NewNode can if the same Node is used twice.
+func nu_check_cycles(first *Node, seen map[*Node]bool) {
+	for n := first; n != nil; n = n.next {
+		if seen[n] {
+			panic(n.positionPrefix() + "Cycle detected in node list at '" + n.content + "'!")
+		}
+		seen[n] = true
+		nu_check_cycles(n.first, seen)
+	}
+}
+
+// Check if a token is a single valid Go number literal, like "42",
+// "0x1F", "1_000" or "6.02e23".
+func nu_is_number(c string) bool {
+	valid := true
+	var s scanner.Scanner
+	file := token.NewFileSet().AddFile("", -1, len(c))
+	s.Init(file, []byte(c), func(token.Position, string) { valid = false }, 0)
+	_, tok, lit := s.Scan()
+	return valid && lit == c && (tok == token.INT || tok == token.FLOAT || tok == token.IMAG)
+}
+
+// Check if a Node is a simple statement that can start a control
+// structure, like the "(:= x (f))" in "(if (:= x (f)) (> x 0) ...)".
+// These are assignments and channel sends like "(<- ch v)". Other
+// expressions can't be told apart from conditions, so they can't be
+// used.
+func nu_is_simple_stmt(n *Node) bool {
+	if n.first == nil {
+		return false
+	}
+	switch n.first.content {
+	case "=", ":=", "+=", "-=", "*=", "/=", "%=", "&=", "|=", "^=", "&^=", "<<=", ">>=", "++", "--":
+		return true
+	case "<-":
+		return n.first.next != nil && n.first.next.next != nil
+	}
+	return false
+}
+
+// Convert the optional simple statement at the start of a control
+// structure's header, like the "(:= x (f))" in "(if (:= x (f)) (> x
+// 0) ...)", into Go like "x := f(); ". It only counts as one if at
+// least "after" Nodes follow it, so that it isn't taken from what
+// must come next. The Node after the statement is returned too, or n
+// itself if there's no statement.
+func nu_init(n *Node, after int) (string, *Node) {
+	if n == nil || !nu_is_simple_stmt(n) {
+		return "", n
+	}
+	rest := 0
+	for m := n.next; m != nil; m = m.next {
+		rest++
+	}
+	if rest < after {
+		return "", n
+	}
+	return nc_action(n) + "; ", n.next
+}
+
+// Check if a Node is a communication for a select case: a send like
+// "(<- ch v)", a receive like "(<- ch)", or a receive assigned to
+// variables like "(:= (v ok) (<- ch))".
+func nu_is_comm(n *Node) bool {
+	if n == nil || n.first == nil {
+		return false
+	}
+	switch n.first.content {
+	case "<-":
+		return n.first.next != nil && (n.first.next.next == nil || n.first.next.next.next == nil)
+	case "=", ":=":
+		recv := n.first.next
+		if recv == nil || recv.next == nil || recv.next.next != nil {
+			return false
+		}
+		recv = recv.next
+		return recv.first != nil && recv.first.content == "<-" && recv.first.next != nil && recv.first.next.next == nil
+	}
+	return false
+}
+
+// Check if a Node is obviously a type rather than a value, being
+// either a type expression like "(* T)" or a predeclared or literal
+// type like "int" or "[]string". Operators that only make types in
+// constraints, like "(| a b)", "*" with more than one operand, and
+// selectors like "(. time Duration)", which may just as well be values,
+// are values.
+func nu_is_type_name(n *Node) bool {
+	if n.content == "" {
+		if n.first == nil {
+			return false
+		}
+		switch n.first.content {
+		case "|", "~", ".":
+			return false
+		case "*":
+			return n.first.next != nil && n.first.next.next == nil
+		}
+		return nu_is_type(n)
+	}
+	switch n.content {
+	case "bool", "byte", "complex64", "complex128", "error", "float32", "float64",
+		"int", "int8", "int16", "int32", "int64", "rune", "string",
+		"uint", "uint8", "uint16", "uint32", "uint64", "uintptr", "any":
+		return true
+	}
+	for _, prefix := range []string{"*", "[", "map[", "chan ", "func("} {
+		if strings.HasPrefix(n.content, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check if a Node is obviously a condition rather than a statement,
+// being either an atom like "ok" or a comparison like "(< i n)".
+func nu_is_condition(n *Node) bool {
+	if n == nil {
+		return false
+	}
+	if n.content != "" {
+		return true
+	}
+	if n.first == nil {
+		return false
+	}
+	switch n.first.content {
+	case "==", "!=", "<", ">", "<=", ">=", "!", "&&", "||":
+		return true
+	}
+	return false
+}
+
+// Find the precedence of a binary operator expression Node, from 1
+// for "(|| a b)" to 5 for "(* a b)" and friends, or 0 if it isn't one.
+func nu_precedence(n *Node) int {
+	if n.content != "" || n.first == nil || n.first.next == nil || n.first.next.next == nil {
+		return 0
+	}
+	switch n.first.content {
+	case "*", "/", "%", "<<", ">>", "&", "&^":
+		return 5
+	case "+", "-", "|", "^":
+		return 4
+	case "==", "!=", "<", "<=", ">", ">=":
+		return 3
+	case "&&":
+		return 2
+	case "||":
+		return 1
+	}
+	return 0
+}
+
+// Convert a value Node into Go for use as the operand of a unary or
+// postfix operator, parenthesizing it if it's an operator expression
+// itself, so "(. (+ a b) x)" → "(a + b).x" and "(- (- x))" → "-(-x)".
+func nu_operand(n *Node) string {
+	if nu_is_unary(n) || nu_precedence(n) != 0 {
+		return "(" + nc_value(n) + ")"
+	}
+	return nc_value(n)
+}
+
+// Check if a Node is one of Go's predeclared constants: "nil", "true",
+// "false" or "iota".
+func nu_is_predeclared_constant(n *Node) bool {
+	switch n.content {
+	case "nil", "true", "false", "iota":
+		return true
+	}
+	return false
+}
+
+// Check if a Node is a unary expression like "(& x)" or "(<- ch)".
+func nu_is_unary(n *Node) bool {
+	if n.content != "" || n.first == nil || n.first.next == nil || n.first.next.next != nil {
+		return false
+	}
+	switch n.first.content {
+	case "+", "-", "!", "^", "*", "&", "<-":
+		return true
+	}
+	return false
+}
+
+// Check if every Node from first to the end of the current level is a
+// cond-like clause: a list of a condition followed by actions, like
+// "((< n 2) (return 1))", or an else clause like "(else (return 2))".
+func nu_is_clauses(first *Node) bool {
+	for n := first; n != nil; n = n.next {
+		if n.content != "" || n.first == nil {
+			return false
+		}
+		if n.first.content == "else" {
+			continue
+		}
+		if n.first.next == nil || !nu_all_lists(n.first.next) {
+			return false
+		}
+	}
+	return true
+}
+
+// Check if every Node from first to the end of the current level is a
+// list, possibly empty.
+func nu_all_lists(first *Node) bool {
+	for n := first; n != nil; n = n.next {
+		if n.content != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// Check if a Node is a block: a list containing only lists of actions,
+// like the "((foo) (bar))" in "(func f () () ((foo) (bar)))". An empty
+// list is an empty block. Calls of function-valued expressions, like
+// "((. mu Lock))", are not blocks.
+func nu_is_block(n *Node) bool {
+	if n.content != "" {
+		return false
+	}
+	if n.first != nil && n.first.first != nil {
+		switch n.first.first.content {
+		case ".", "index", "lambda", "func":
+			return false
+		}
+	}
+	return nu_all_lists(n.first)
+}
+
+// Convert a sequence of action Nodes into Go statements. Blocks are
+// spliced in as if their contents had been written inline, so both
+// "(func f () () (foo) (bar))" and "(func f () () ((foo) (bar)))"
+// have the same body.
+func nu_body(first *Node) string {
+	return nu_process_many(first, nc_action)
+}
+
+// Check if a function declaration's Node after the name is a list of
+// type parameters, as in "(func F ((T any)) ((x T)) (T) ((return
+// x)))". Generic functions must have their body in a single block,
+// since that's what tells the type parameters apart from the regular
+// parameters.
+func nu_is_type_params(n *Node) bool {
+	if n.first == nil || n.next == nil || !nu_all_lists(n.first) {
+		return false
+	}
+	results := n.next.next
+	return results != nil && results.next != nil && results.next.next == nil && nu_is_block(results.next)
+}
+
+// Convert a list of type parameters into Go, including the brackets.
+// Each entry is converted like a field, so "((K comparable) (V any))"
+// → "[K comparable, V any]", except that an entry of only type
+// parameter names, like "(T U)", gets the "any" constraint, as in "[T,
+// U any]". A list of only tokens is a single entry, so "(T any)" →
+// "[T any]".
+func nu_type_params(n *Node) string {
+	entries := []*Node{n}
+	if !nu_all_atoms(n.first) {
+		entries = entries[:0]
+		for entry := n.first; entry != nil; entry = entry.next {
+			entries = append(entries, entry)
+		}
+	}
+	out := ""
+	for _, entry := range entries {
+		if entry.content == "" && entry.first != nil && nu_all_atoms(entry.first) && nu_is_type_param_name(entry.last) {
+			out += nu_raw_content(entry.first, ", ") + " any, "
+		} else {
+			out += nu_field(entry) + ", "
+		}
+	}
+	return "[" + out[:len(out)-len(", ")] + "]"
+}
+
+// Check if a token looks like a type parameter name rather than a
+// constraint, by being a capital letter optionally followed by digits,
+// like "T" or "T2".
+func nu_is_type_param_name(n *Node) bool {
+	c := n.content
+	if c == "" || c[0] < 'A' || c[0] > 'Z' {
+		return false
+	}
+	for i := 1; i < len(c); i++ {
+		if c[i] < '0' || c[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Convert a field-like entry into Go. Entries are used for parameters,
+// results, and struct fields. They can be a plain type ("int" or
+// "(int)"), a type expression ("(* Server)"), or names followed by a
+// type ("(a b int)" → "a, b int").
+func nu_field(entry *Node) string {
+	names, typ := nu_field_parts(entry)
+	if names == "" {
+		return typ
+	}
+	return names + " " + typ
+}
+
+// Convert a struct field entry into Go like nu_field does, except
+// that a string after the type is the field's tag. Tags are written
+// with backquotes unless they contain one, since that's how Go tags
+// are usually written.
+func nu_struct_field(entry *Node) string {
+	tag := entry.last
+	if entry.content != "" || tag == entry.first || tag.content == "" || tag.content[0] != '"' && tag.content[0] != '`' {
+		return nu_field(entry)
+	}
+	names := ""
+	n := entry.first
+	for ; n.next != tag; n = n.next {
+		names += n.content + ", "
+	}
+	out := nc_type(n)
+	if names != "" {
+		out = names[:len(names)-len(", ")] + " " + out
+	}
+	text := nu_unquote(tag)
+	if strings.Contains(text, "`") {
+		return out + " " + strconv.Quote(text)
+	}
+	return out + " `" + text + "`"
+}
+
+// Split a field-like entry into its comma-separated names, which are
+// empty for a plain type, and its type.
+func nu_field_parts(entry *Node) (string, string) {
+	switch {
+	case entry.content != "":
+		return "", nc_type(entry)
+	case entry.first == nil:
+		panic("Empty field entry!")
+	case entry.first.next == nil:
+		return "", nc_type(entry.first)
+	case nu_is_type(entry):
+		return "", nc_type(entry)
+	}
+	names := ""
+	n := entry.first
+	for ; n.next != nil; n = n.next {
+		names += n.content + ", "
+	}
+	return names[:len(names)-len(", ")], nc_type(n)
+}
+
+// Convert a list of function parameters into Go, without the
+// surrounding parentheses. A list of only plain tokens, like
+// "(n int)", is passed through as-is. Otherwise each entry is
+// converted with nu_field, and consecutive named parameters of the
+// same type are grouped, so "((a int) (b int) (s string))" → "a, b
+// int, s string".
+func nu_params(first *Node) string {
+	if nu_all_atoms(first) {
+		return nu_raw_content(first, " ")
+	}
+	out := ""
+	names, typ := "", ""
+	for n := first; n != nil; n = n.next {
+		nextNames, nextTyp := nu_field_parts(n)
+		if names != "" && nextNames != "" && nextTyp == typ {
+			names += ", " + nextNames
+			continue
+		}
+		if typ != "" {
+			out += strings.TrimLeft(names+" "+typ, " ") + ", "
+		}
+		names, typ = nextNames, nextTyp
+	}
+	if typ != "" {
+		out += strings.TrimLeft(names+" "+typ, " ")
+	}
+	return out
+}
+
+// Convert a list of field-like entries into Go, separating them with
+// commas. Each entry is converted with nu_field, so "((* Server)
+// error)" → "*Server, error".
+func nu_fields(first *Node) string {
+	out := ""
+	for n := first; n != nil; n = n.next {
+		out += nu_field(n) + ", "
+	}
+	if out == "" {
+		return out
+	}
+	return out[:len(out)-len(", ")]
+}
+
+// Convert a list of function results into Go, including the
+// surrounding parentheses when there are several results or they're
+// named, so "(int)" → " int" but "((n int) (err error))" → "(n int,
+// err error)".
+func nu_results(first *Node) string {
+	if first == nil {
+		return ""
+	}
+	if first.next == nil && (first.content != "" || first.first == nil || first.first.next == nil || nu_is_type(first)) {
+		return " " + nu_field(first)
+	}
+	return "(" + nu_fields(first) + ")"
+}
+
+// Convert the values of a Golid "(case value ... body ...)" clause
+// into Go, returning them with the first Node of the clause's body. A
+// list is a single value, as in "(case (< x 0) ...)", and otherwise
+// every leading token is a value, as in "(case 1 2 ...)".
+func nu_case_values(first *Node) (string, *Node) {
+	if first.content == "" {
+		return nc_value(first), first.next
+	}
+	out := ""
+	n := first
+	for ; n != nil && n.content != ""; n = n.next {
+		out += nu_literal(n) + ", "
+	}
+	return out[:len(out)-len(", ")], n
+}
+
+// Convert the left-hand side of an assignment into Go. A list of
+// targets like "(v ok)" becomes "v, ok", but a list that's a value
+// expression like "(. b Name)" is a single target.
+func nu_targets(n *Node) string {
+	if n.content != "" || n.first == nil || nc_value_func(n.first.content) != nil {
+		return nc_value(n)
+	}
+	out := ""
+	for target := n.first; target != nil; target = target.next {
+		out += nc_value(target) + ", "
+	}
+	return out[:len(out)-len(", ")]
+}
+
+// Convert the elements of a composite literal into Go, separating
+// them with commas. If the element type is a type expression like
+// "(struct ...)", then elements which would otherwise be function
+// calls, like "("x" 1)", are instead converted into literals without
+// the type, like "{"x", 1}".
+func nu_elements(elemType *Node, first *Node) string {
+	elided := nu_is_elidable(elemType)
+	var out strings.Builder
+	for n := first; n != nil; n = n.next {
+		if n != first {
+			out.WriteString(", ")
+		}
+		if elided && n.first != nil && nc_value_func(n.first.content) == nil {
+			out.WriteString("{" + nu_elements(nil, n.first) + "}")
+		} else {
+			out.WriteString(nc_value(n))
+		}
+	}
+	return out.String()
+}
+
+// Check if composite literal elements of the given type can leave out
+// their type, as with "(slice int)" and "(struct ...)", but not "(*
+// T)".
+func nu_is_elidable(elemType *Node) bool {
+	return elemType != nil && nu_is_type(elemType) && elemType.first.content != "*"
+}
+
+// Find the first element of a composite literal. Elements can be
+// wrapped in a list when the first one is a literal, as in "(slice int
+// (1 2 3))", since a literal can't be called like a function. Elements
+// with elided types are never unwrapped, so "(slice (slice int) (1
+// 2))" is still "[][]int{{1, 2}}".
+func nu_unwrap_elements(elemType *Node, first *Node) *Node {
+	if nu_is_elidable(elemType) {
+		return first
+	}
+	if first != nil && first.next == nil && first.first != nil && nu_literal_type(first.first) != "" {
+		return first.first
+	}
+	return first
+}
+
+// Guess the Go type of a literal value Node like "\"text\"", "'r'",
+// "1", "1.5" or "true", or return "" if it isn't a literal.
+func nu_literal_type(n *Node) string {
+	c := n.content
+	switch {
+	case c == "":
+		return ""
+	case c[0] == '"' || c[0] == '`':
+		return "string"
+	case c[0] == '\'':
+		return "rune"
+	case c == "true" || c == "false":
+		return "bool"
+	case c[0] < '0' || c[0] > '9':
+		return ""
+	case strings.HasPrefix(c, "0x") || strings.HasPrefix(c, "0X"):
+		return "int"
+	case strings.ContainsAny(c, ".eE"):
+		return "float64"
+	}
+	return "int"
+}
+
+// Convert a token Node into Go, making sure that string, rune and
+// number literals are valid Go. Tokens keep their quotes, so that's
+// what tells a literal apart from an identifier: a double-quoted token
+// is a string, a backquoted token is a raw string, and a single-quoted
+// token is a rune. Strings are passed through as written unless they
+// span lines, in which case they're quoted again with strconv.Quote to
+// turn the line breaks into "\n" escapes. Invalid escapes and numbers
+// like "1a2" cause a panic.
+func nu_literal(n *Node) string {
+	c := n.content
+	if c[0] >= '0' && c[0] <= '9' && !nu_is_number(c) {
+		panic("Invalid number literal: " + c)
+	}
+	switch c[0] {
+	case '"':
+		s, err := strconv.Unquote(strings.ReplaceAll(c, "\n", `\n`))
+		if err != nil {
+			panic("Invalid string literal: " + c)
+		}
+		if strings.Contains(c, "\n") {
+			return strconv.Quote(s)
+		}
+	case '\'':
+		if _, err := strconv.Unquote(c); err != nil {
+			panic("Invalid rune literal: " + c)
+		}
+	}
+	return c
+}
+
+// Get the text of a string literal token, panicking if it's invalid.
+func nu_unquote(n *Node) string {
+	s, _ := strconv.Unquote(nu_literal(n))
+	return s
+}
@@ -3,15 +3,21 @@
 package parse
 
 import (
+	"errors"
 	"fmt"
 	"regexp"
+	"sort"
+	"strings"
 )
 
 // Find shortest sequence of double quote, followed by escaped and unescaped characters, followed by double quote
 var stringRegex = regexp.MustCompile(`"([^"\\]|\\.)*"`)
 
-// Find a single quoted character, which consists of a single quote, an optional backslash, any character, and a closing single quote.
-var charRegex = regexp.MustCompile(`'\\?.'`)
+// Find a raw string, which is everything from a backquote to the next backquote
+var rawStringRegex = regexp.MustCompile("`[^`]*`")
+
+// Find a single quoted character, which consists of a single quote, either an escape sequence or any other character, and a closing single quote.
+var charRegex = regexp.MustCompile(`'(\\.[^']*|[^'\\])'`)
 
 // Find longest sequence of non-syntax characters
 var tokenRegex = regexp.MustCompile("[^ \t\n\"()]+")
@@ -19,6 +25,7 @@ var tokenRegex = regexp.MustCompile("[^ \t\n\"()]+")
 // Given a string starting with a token, find the end of the token (the index of the first character that follows the token).
 // Rules:
 // * If the token starts with a double quote ("), the token ends at the next double quote that isn't backslash-escaped.
+// * If the token starts with a backquote (`), the token ends at the next backquote.
 // * Otherwise the token ends right before the next syntax character.
 // Returns a negative value if there is no valid token.
 func findTokenEnd(s string) int {
@@ -26,6 +33,8 @@ func findTokenEnd(s string) int {
 	switch s[0] {
 	case '"':
 		re = stringRegex
+	case '`':
+		re = rawStringRegex
 	case '\'':
 		re = charRegex
 	default:
@@ -67,32 +76,150 @@ func indentSrfi49(depthChange int, node *Node) *Node {
 // Parse a Golid string into its syntax tree, automatically
 // determining which top-level blocks of code use which syntax.
 func parseString(s string) (Expression, error) {
+	root, err := parseRoot(s)
+	if err != nil {
+		return nil, err
+	}
+
+	// remove extra layers (e.g., if this was ran for less than a file)
+	for root != nil && root.first == root.last && root.content == "" {
+		root = root.first
+	}
+
+	return root, nil
+}
+
+// Parse a whole Golid file into a root Node whose children are the
+// file's top-level Nodes, in order. Unlike parsing less than a file,
+// a file with only one form like "(package main)" keeps its root, but
+// a file whose forms are all wrapped in one list, as printed by
+// Node.String, is unwrapped. The file must have exactly one package
+// declaration, and only "(generated)" and "(go:build ...)" headers can
+// come before it.
+func ParseFile(src string) (*Node, error) {
+	root, err := parseRoot(src)
+	if err != nil {
+		return nil, err
+	}
+	for root.first != nil && root.first == root.last && root.first.content == "" && root.first.first != nil && root.first.first.content == "" {
+		root = root.first
+	}
+	return root, checkFile(root)
+}
+
+// Check that a file's top-level Nodes have exactly one package
+// declaration, with only header forms before it. A package
+// declaration may have a doc comment, as in "(doc \"Package p ...\"
+// (package p))".
+func checkFile(root *Node) error {
+	var pkg *Node
+	for n := root.first; n != nil; n = n.next {
+		head := ""
+		if n.first != nil {
+			head = n.first.content
+		}
+		if decl := n.last; head == "doc" && decl != n.first && decl.first != nil {
+			head = decl.first.content
+		}
+		switch {
+		case head == "package" && pkg != nil:
+			return fmt.Errorf("%sThere can only be one package declaration: %v", n.positionPrefix(), n)
+		case head == "package":
+			pkg = n
+		case pkg == nil && head != "generated" && head != "go:build":
+			return fmt.Errorf("%sExpected the package declaration before: %v", n.positionPrefix(), n)
+		}
+	}
+	if pkg == nil {
+		return errors.New("Missing package declaration")
+	}
+	return nil
+}
+
+// Parse a Golid string into a root Node whose children are the
+// string's top-level Nodes.
+func parseRoot(s string) (*Node, error) {
 	root := Root() // top-level node
 
+	// find the line and column of what's left of s in the original
+	// string, by binary searching the offsets of its newlines
+	orig := s
+	newlines := []int{}
+	for i, c := range orig {
+		if c == '\n' {
+			newlines = append(newlines, i)
+		}
+	}
+	position := func(n *Node) {
+		offset := len(orig) - len(s)
+		line := sort.SearchInts(newlines, offset)
+		lineStart := 0
+		if line > 0 {
+			lineStart = newlines[line-1] + 1
+		}
+		n.line, n.col = line+1, offset-lineStart+1
+	}
+
+	// Comments are kept until the next token, and then attached to the
+	// list it starts. Nothing would print comments before a token in
+	// the middle of a list, or at the end of one, so those are errors.
+	comments := []string{}
+	commentStart := &Node{} // where the kept comments start
+	skipComment := func() {
+		if len(comments) == 0 {
+			position(commentStart)
+		}
+		end := strings.IndexByte(s, '\n')
+		if end < 0 {
+			end = len(s)
+		}
+		comments = append(comments, strings.TrimSpace(strings.TrimLeft(s[:end], ";")))
+		s = s[end:]
+	}
+	misplacedComment := func(where string) error {
+		return fmt.Errorf("%sComments can only go before a form, not %s.", commentStart.positionPrefix(), where)
+	}
+
 	// process a top-level node
 	doTopNode := func() error {
 		isens := true // Indentation SENSitivity
+		n := root.MakeChild()
+		position(n)
 		if s[0] == '(' {
 			isens = false
 			s = s[1:] // don't make the same node twice
 		}
-		tabDepth := 0 // for indent-grouping syntax
-		n := root.MakeChild()
+		tabDepth := 0     // for indent-grouping syntax
+		commentDepth := 0 // indentation of the last kept comment
 	loop: // go until break or out of code
 		for s != "" {
 			switch s[0] {
 			case '(': // go deeper
 				n = n.MakeChild()
+				position(n)
 				s = s[1:]
 			case ')': // go up
+				if len(comments) > 0 {
+					return misplacedComment("at the end of a list")
+				}
+				if n == root {
+					extra := &Node{}
+					position(extra)
+					return fmt.Errorf("%sUnmatched ')' with no list to close.", extra.positionPrefix())
+				}
 				n = n.Parent()
 				s = s[1:]
 			case ' ', '\t': // ignore mid-line whitespace
 				s = s[1:]
 			case '\n': // whitespace, but may end node
-				// get to the good stuff
+				// get to the good stuff, keeping comment lines on the way
 				for s != "" && s[0] == '\n' {
 					s = s[1:]
+					if line := strings.TrimLeft(s, " \t"); line != "" && line[0] == ';' {
+						commentDepth = len(s) - len(strings.TrimLeft(s, "\t"))
+						s = line
+						skipComment()
+					}
 				}
 				if !isens { // paren-only syntax
 					if n == root {
@@ -102,6 +229,9 @@ func parseString(s string) (Expression, error) {
 					}
 				}
 				if s == "" || s[0] != '\t' {
+					if len(comments) > 0 && commentDepth > 0 {
+						return misplacedComment("at the end of an indented block")
+					}
 					break loop
 				}
 				newDepth := 0
@@ -109,23 +239,38 @@ func parseString(s string) (Expression, error) {
 					newDepth++
 					s = s[1:]
 				}
+				if len(comments) > 0 && commentDepth > newDepth {
+					return misplacedComment("at the end of an indented block")
+				}
 				n = indentSrfi49(newDepth-tabDepth, n)
-				tabDepth = newDepth
-			case ';': // skip rest of line
-				for s != "" && s[0] != '\n' {
-					s = s[1:]
+				for p := n; p != nil && p.line == 0; p = p.parent {
+					position(p)
 				}
-				s = s[1:] // alse skip trailing '\n'
+				tabDepth = newDepth
+			case ';': // skip rest of line, leaving the '\n' to end it
+				commentDepth = tabDepth
+				skipComment()
 			default: // must be a token, finally
 				end := findTokenEnd(s)
 				if end < 0 {
 					return fmt.Errorf("Could not find end of token %s.", s)
 				} else {
 					n.AddToken(s[0:end])
+					position(n.last)
+					if len(comments) > 0 {
+						if n.first != n.last {
+							return misplacedComment(fmt.Sprintf("before %q in the middle of a list", n.last.content))
+						}
+						n.comments = comments
+						comments = []string{}
+					}
 					s = s[end:]
 				}
 			}
 		}
+		if !isens && n != root {
+			return fmt.Errorf("%sUnclosed '(' at end of input.", n.positionPrefix())
+		}
 		return nil
 	}
 
@@ -142,9 +287,7 @@ func parseString(s string) (Expression, error) {
 		case '\n': // newline to note and pass
 			s = s[1:]
 		case ';': // comment to skip
-			for s[0] != '\n' {
-				s = s[1:]
-			}
+			skipComment()
 		default: // we've reached the next node
 			err := doTopNode()
 			if err != nil {
@@ -153,10 +296,5 @@ func parseString(s string) (Expression, error) {
 		}
 	}
 
-	// remove extra layers (e.g., if this was ran for less than a file)
-	for root != nil && root.first == root.last && root.content == "" {
-		root = root.first
-	}
-
 	return root, nil
 }
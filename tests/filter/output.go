@@ -0,0 +1,18 @@
+package main
+
+import "fmt"
+
+func Filter[T any](s []T, pred func(T) bool) []T {
+	out := make([]T, 0)
+	for _, v := range s {
+		if pred(v) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func main() {
+	evens := Filter([]int{1, 2, 3, 4}, func(n int) bool { return n%2 == 0 })
+	fmt.Println(evens)
+}
@@ -0,0 +1,20 @@
+package main
+
+import "fmt"
+
+func gen(n int) chan int {
+	ch := make(chan int)
+	go func() {
+		for i := range n {
+			ch <- i
+		}
+		close(ch)
+	}()
+	return ch
+}
+
+func main() {
+	for v := range gen(3) {
+		fmt.Printf("got %d\n", v)
+	}
+}
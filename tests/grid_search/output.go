@@ -0,0 +1,23 @@
+package main
+
+import "fmt"
+
+func find(grid [][]int, target int) (int, int) {
+	fi, fj := -1, -1
+search:
+	for i, row := range grid {
+		for j, v := range row {
+			if v == target {
+				fi, fj = i, j
+				break search
+			}
+		}
+	}
+	return fi, fj
+}
+
+func main() {
+	grid := [][]int{{1, 2, 3}, {4, 5, 6}}
+	fmt.Println(find(grid, 5))
+	fmt.Println(find(grid, 7))
+}
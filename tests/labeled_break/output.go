@@ -0,0 +1,18 @@
+package main
+
+import "fmt"
+
+func main() {
+	x := 0
+loop:
+	for {
+		x++
+		switch x {
+		case 3:
+			break loop
+		default:
+			fmt.Printf("x==%d\n", x)
+		}
+	}
+	fmt.Printf("left loop at x==%d\n", x)
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+var mu = new(sync.Mutex)
+
+var count = 0
+
+func increment() {
+	mu.Lock()
+	defer mu.Unlock()
+	count++
+}
+
+func main() {
+	increment()
+	increment()
+	fmt.Printf("count==%d\n", count)
+}
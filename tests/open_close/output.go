@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func open(path string) (*os.File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func describe(path string) {
+	f, err := open(path)
+	if err != nil {
+		fmt.Println("could not open", path)
+		return
+	}
+	defer f.Close()
+	fmt.Println("opened", f.Name())
+}
+
+func main() {
+	describe("classic.gol")
+	describe("missing.gol")
+}
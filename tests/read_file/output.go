@@ -0,0 +1,21 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func read(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	return data, nil
+}
+
+func main() {
+	data, err := read("classic.gol")
+	fmt.Println(len(data) > 0, err)
+	data, err = read("missing.gol")
+	fmt.Println(len(data), err)
+}
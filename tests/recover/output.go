@@ -0,0 +1,21 @@
+package main
+
+import "fmt"
+
+func risky() {
+	panic("oops")
+}
+
+func safe() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("recovered: %v", r)
+		}
+	}()
+	risky()
+	return
+}
+
+func main() {
+	fmt.Println(safe())
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+func keys(m map[string]int) []string {
+	ks := make([]string, 0, len(m))
+	for k, _ := range m {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+	return ks
+}
+
+func main() {
+	m := map[string]int{"b": 2, "c": 3, "a": 1}
+	fmt.Println(keys(m))
+}
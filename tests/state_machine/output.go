@@ -0,0 +1,34 @@
+package main
+
+import "fmt"
+
+const (
+	Start = iota
+	Middle
+	End
+	Broken
+)
+
+var state = Start
+
+func step() bool {
+	switch state {
+	case Start:
+		state = Middle
+		fallthrough
+	case Middle:
+		state = End
+	case End:
+		return true
+	default:
+		return false
+	}
+	return false
+}
+
+func main() {
+	fmt.Println(step(), state)
+	fmt.Println(step(), state)
+	state = Broken
+	fmt.Println(step(), state)
+}
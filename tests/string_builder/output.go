@@ -0,0 +1,18 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+func join(parts []string) string {
+	b := strings.Builder{}
+	for _, p := range parts {
+		(&b).WriteString(p)
+	}
+	return (&b).String()
+}
+
+func main() {
+	fmt.Println(join([]string{"a", "b", "c"}))
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+func timed(name string) {
+	start := time.Now()
+	defer func() {
+		log.Printf("%s took %v", name, time.Since(start))
+	}()
+	fmt.Println("working on", name)
+}
+
+func main() {
+	timed("task")
+}